@@ -4,9 +4,9 @@ import (
 	"context"
 	"github.com/unchainese/unchain/internal/global"
 	"github.com/unchainese/unchain/internal/node"
+	"log"
 	"os"
 	"os/signal"
-	"time"
 )
 
 func main() {
@@ -18,12 +18,15 @@ func main() {
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt)
 
-	app := node.NewApp(c, stop)
+	app, err := node.NewApp(node.WithConfig(c), node.WithConfigFile(global.ConfigFile), node.WithSignalChannel(stop))
+	if err != nil {
+		log.Fatalln(err)
+	}
 	app.PushNode()//register node info to the manager server
 	app.PrintVLESSConnectionURLS()//for standalone node
 	go app.Run()
 	<-stop
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), c.DrainNotifyPeriod()+c.GracefulShutdownTimeout()+c.ForceShutdownTimeout())
 	defer cancel()
 	app.Shutdown(ctx)
 }
@@ -1,26 +1,139 @@
 package global
 
 import (
+	"bytes"
+	"encoding/hex"
 	"fmt"
 	"github.com/BurntSushi/toml"
+	"github.com/google/uuid"
 	"log"
 	"log/slog"
+	"net"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 )
 
 type Config struct {
-	SubAddresses       []string `desc:"sub addresses" example:"node1.xxx.cn:80,node2.xxx.cn:443"`
-	ListenAddr         string   `desc:"net listen addr" def:"0.0.0.0:80"`
-	RegisterUrl        string   `desc:"register url" def:"https://admin.unchain.people.from.censorship"`
-	RegisterToken      string   `desc:"register token" def:"unchain people from censorship and surveillance"`
-	AllowUsers         string   `desc:"allow users" def:"" example:"903bcd04-79e7-429c-bf0c-0456c7de9cdc,903bcd04-79e7-429c-bf0c-0456c7de9cd1"`
-	LogFile            string   `desc:"log file path" def:""`
-	DebugLevel         string   `desc:"debug level" def:"DEBUG"`
-	PushIntervalSecond int      `desc:"push interval" def:"360"` //seconds
-	GitHash            string   `desc:"git hash" def:""`
-	BuildTime          string   `desc:"build time" def:""`
+	SubAddresses                 []string            `desc:"sub addresses" example:"node1.xxx.cn:80,node2.xxx.cn:443"`
+	ListenAddr                   string              `desc:"net listen addr" def:"0.0.0.0:80"`
+	RegisterUrl                  string              `desc:"register url" def:"https://admin.unchain.people.from.censorship"`
+	RegisterToken                string              `desc:"register token" def:"unchain people from censorship and surveillance"`
+	AllowUsers                   string              `desc:"allow users" def:"" example:"903bcd04-79e7-429c-bf0c-0456c7de9cdc,903bcd04-79e7-429c-bf0c-0456c7de9cd1"`
+	LogFile                      string              `desc:"log file path" def:""`
+	DebugLevel                   string              `desc:"debug level" def:"DEBUG"`
+	PushIntervalSecond           int                 `desc:"push interval" def:"360"` //seconds
+	NodeTags                     []string            `desc:"node tags reported to the register server" example:"us,premium"`
+	UseSelfAsHandler             bool                `desc:"serve http with App.ServeHTTP instead of the bare mux" def:"false"`
+	PreserveSNI                  bool                `desc:"dial the server name of a client hello instead of an ip target, the client tls is piped untouched" def:"false"`
+	WriteBufferSize              int                 `desc:"coalesce small writes to the destination into a buffer of this size, 0 disables" def:"0"`
+	WriteBufferFlushMs           int                 `desc:"flush interval of the coalescing write buffer" def:"5"` //milliseconds
+	TLSPassthroughPorts          []int               `desc:"destination ports dialed as raw tcp, the payload is already tls" def:"443"`
+	UseTLSEgress                 bool                `desc:"dial tls to destinations on TLSEgressPorts whose client payload is not tls already" def:"false"`
+	TLSEgressPorts               []int               `desc:"destination ports UseTLSEgress wraps in tls" def:""`
+	AdminToken                   string              `desc:"authorization token of the /admin endpoints, empty disables them" def:""`
+	TLSCertFile                  string              `desc:"tls certificate file, serve https when set" def:""`
+	TLSKeyFile                   string              `desc:"tls private key file" def:""`
+	ManualCertRotation           bool                `desc:"reload TLSCertFile and TLSKeyFile on SIGUSR1" def:"false"`
+	CertRotationWebhook          string              `desc:"url notified with a POST when the served certificate changes" def:""`
+	AllowedBridgeTargets         []string            `desc:"host:port targets of the /bridge endpoint, supports * wildcards" example:"10.0.0.5:22,*.internal.lan:*"`
+	StripChunkedEncoding         bool                `desc:"decode small chunked http/1.x request bodies before forwarding" def:"false"`
+	DeprecationDeadline          string              `desc:"date after which deprecated endpoints answer 410 Gone" def:"" example:"2025-12-31"`
+	SmuxEnabled                  bool                `desc:"accept smux multiplexed sessions, each stream carries its own VLESS request" def:"false"`
+	ProbeResistance              bool                `desc:"answer everything but VLESS upgrades and subscriptions with a decoy website" def:"false"`
+	DecoyTLSCertFile             string              `desc:"certificate served when TLSCertFile is empty and ProbeResistance is set" def:""`
+	DecoyTLSKeyFile              string              `desc:"private key of DecoyTLSCertFile" def:""`
+	DecoyResponseFile            string              `desc:"html body of the decoy website" def:""`
+	GracefulShutdownSecond       int                 `desc:"seconds to wait for connections to finish on shutdown" def:"30"`
+	ForceShutdownSecond          int                 `desc:"seconds to wait for forcibly closed connections after the graceful period" def:"5"`
+	DNSCacheMaxEntries           int                 `desc:"cached dns responses of udp sessions to port 53, 0 disables the cache" def:"0"`
+	GracefulRestartEnabled       bool                `desc:"on SIGUSR2 start a new process on the same listener and drain this one" def:"false"`
+	NegativeDNSTTLSecond         int                 `desc:"seconds a failed destination lookup is cached" def:"5"`
+	MaxDNSTTLSecond              int                 `desc:"most seconds a resolved destination is cached, answers expire with their dns ttl" def:"60"`
+	UpstreamReadBufSize          int                 `desc:"buffer size reading client to destination traffic" def:"8192"`
+	DownstreamReadBufSize        int                 `desc:"buffer size reading destination to client traffic" def:"8192"`
+	UseQUIC                      bool                `desc:"also serve http/3 over quic on the udp port of ListenAddr" def:"false"`
+	QUICCertFile                 string              `desc:"tls certificate of the quic listener" def:""`
+	QUICKeyFile                  string              `desc:"tls private key of the quic listener" def:""`
+	DrainingCloseAfterSecond     int                 `desc:"seconds a connection accepted while draining is kept open" def:"10"`
+	DrainNotifyPeriodSecond      int                 `desc:"seconds Shutdown keeps accepting with X-Server-Closing before stopping" def:"0"`
+	StaleTimeoutSecond           int                 `desc:"close connections that moved no bytes within this many seconds, 0 disables" def:"0"`
+	WebSocketPath                string              `desc:"websocket path in subscription urls, {uid} is replaced by the user id" def:"/wsv/{uid}"`
+	SubAddressPaths              map[string]string   `desc:"websocket path override per sub address" example:"node2.xxx.cn:443=/proxy/wsv/{uid}"`
+	GlobalMaxBandwidthMbps       int                 `desc:"bandwidth shared by all connections in megabits per second, 0 is unlimited" def:"0"`
+	TargetRewriteRules           map[string]string   `desc:"destination host:port rewritten to another host:port before dialing" example:"example.com:443=canary.example.com:443"`
+	DisableNagle                 bool                `desc:"set TCP_NODELAY on the client and destination connections" def:"false"`
+	HandshakeTimeoutSecond       int                 `desc:"seconds a client has to send the vless request after the websocket upgrade" def:"5"`
+	StormThreshold               int                 `desc:"websocket connections per second before accepting is slowed down, 0 disables" def:"0"`
+	PeakHoursStart               int                 `desc:"hour of day the peak period starts, 0-23" def:"0"`
+	PeakHoursEnd                 int                 `desc:"hour of day the peak period ends, 0-23, equal to PeakHoursStart disables" def:"0"`
+	PeakBandwidthKBps            int                 `desc:"bandwidth of each connection during peak hours in KB/s, 0 is unlimited" def:"0"`
+	OffPeakBandwidthKBps         int                 `desc:"bandwidth of each connection outside peak hours in KB/s, 0 is unlimited" def:"0"`
+	MinAllowedUsers              int                 `desc:"push responses with fewer users are rejected while users are allowed, 0 accepts empty responses" def:"0"`
+	WriteTimeoutSecond           int                 `desc:"seconds a websocket write to a slow client may block" def:"30"`
+	PSK                          string              `desc:"pre-shared key clients send as Authorization: PSK <key> with the websocket upgrade, empty disables" def:""`
+	DebugPCAPPath                string              `desc:"debug only, write the cleartext of all tcp sessions to this pcap file" def:""`
+	UseMPTCP                     bool                `desc:"dial destinations with multipath tcp, falls back to tcp when the kernel has no mptcp" def:"false"`
+	StrictVLESSValidation        bool                `desc:"close websockets whose first message is not a vless request of an allowed user" def:"false"`
+	ObfuscationBlockSize         int                 `desc:"pad vless websocket messages with random bytes to multiples of this size, clients must pad too, 0 disables" def:"0"`
+	RedisAddr                    string              `desc:"redis shared by active-active nodes for users and traffic, empty disables" example:"127.0.0.1:6379"`
+	RedisPassword                string              `desc:"redis password" def:""`
+	EnableECN                    bool                `desc:"check at startup that the kernel negotiates ECN on outgoing tcp connections" def:"false"`
+	MaxConcurrentConns           int                 `desc:"active vless connections the node accepts, 0 is unlimited" def:"0"`
+	PressureMinScore             float64             `desc:"above 90% of MaxConcurrentConns only users with a ConnectionScore of at least this are accepted" def:"0.2"`
+	EnableTFO                    bool                `desc:"dial destinations with tcp fast open on linux, falls back to a normal handshake" def:"false"`
+	DNSPrefetchTopN              int                 `desc:"re-resolve the most used destination hosts before their dns cache entry expires, 0 disables" def:"0"`
+	FlowControlWindowBytes       int                 `desc:"grant clients that send the flow-control addon a window update after every this many bytes, 0 disables" def:"0"`
+	TrafficClassification        bool                `desc:"classify connections as interactive, bulk or streaming after 2 seconds and tune their sockets" def:"false"`
+	InjectHeaders                map[string]string   `desc:"headers set on plain http/1 requests passing through the tunnel" example:"X-Custom-Auth=secret"`
+	InjectTraceID                bool                `desc:"set the trace-id addon of a session as X-Trace-Id on its plain http/1 requests, otherwise the id is only logged" def:"false"`
+	SingleSessionPerUser         bool                `desc:"allow the sessions of a user from one ip at a time" def:"false"`
+	SessionConflictPolicy        string              `desc:"reject_new or kick_old, used when a user connects from a second ip" def:"reject_new"`
+	LogSNIMismatch               bool                `desc:"warn when a vless target is unrelated to the Host the websocket was opened with" def:"false"`
+	MaxSessionDurationSecond     int                 `desc:"close sessions open longer than this many seconds, 0 is unlimited" def:"0"`
+	MemoryPressureThresholdMB    int                 `desc:"refuse websocket upgrades with 503 while the heap is larger than this, 0 disables" def:"0"`
+	PACRoutedDomains             []string            `desc:"domains the pac subscription sends through PACProxyAddr, subdomains included" example:"google.com,youtube.com"`
+	PACProxyAddr                 string              `desc:"local socks5 proxy of the pac subscription" def:"127.0.0.1:1080"`
+	LongPollingFallback          bool                `desc:"serve vless over long polling http requests with the X-VLESS-Fallback: long-poll header" def:"false"`
+	UpstreamServers              []string            `desc:"host:port servers every session is dialed to instead of its vless target, chosen per user by rendezvous hashing" example:"10.0.0.1:8080,10.0.0.2:8080"`
+	AffinityByIP                 bool                `desc:"choose the upstream server by client ip instead of user id" def:"false"`
+	ErrorRateAlertThreshold      float64             `desc:"alert when more than this share of the dials to a target failed within a minute, 0 disables" def:"0"`
+	AlertWebhookURL              string              `desc:"url the target error rate alerts are posted to as json" example:"https://alerts.example.com/hook"`
+	AlertCooldownSecond          int                 `desc:"seconds between two alerts of the same target" def:"300"`
+	RejectTLS12                  bool                `desc:"only accept tls 1.3 clients" def:"false"`
+	LogTLS12                     bool                `desc:"log tls 1.2 handshakes, a possible downgrade, ignored when RejectTLS12 is set" def:"false"`
+	VLESSCompression             bool                `desc:"zstd compress the ws messages of tcp sessions whose client asks for it with the zstd=1 addon" def:"false"`
+	VLESSCompressionLevel        int                 `desc:"zstd level of VLESSCompression, 1 fastest to 22 smallest" def:"3"`
+	OnionRelays                  []string            `desc:"chain of emissary nodes tcp sessions are tunneled through before the target, each as ws(s)://uuid@host/path with its own user" example:"wss://uuid1@relay1.example.com/wsv/uuid1,wss://uuid2@relay2.example.com/wsv/uuid2"`
+	UsePostQuantumKEM            bool                `desc:"prefer the hybrid post quantum key exchange of the go toolchain for tls clients" def:"false"`
+	FreeBytesPerUser             int64               `desc:"bytes of every user per calendar month reported as free traffic, the rest as billed, 0 disables the split" def:"0"`
+	TunnelWatchdogIntervalSecond int                 `desc:"seconds between websocket pings verifying the client still answers, 0 disables the watchdog" def:"0"`
+	TunnelWatchdogTimeoutSecond  int                 `desc:"seconds the pong of a watchdog ping may take before the connection is closed as stuck" def:"10"`
+	ExtraHostsFile               string              `desc:"hosts file read after /etc/hosts, its entries answer destination lookups before dns, reloaded on SIGHUP" example:"/etc/emissary/hosts"`
+	AdaptiveBufferSize           bool                `desc:"resize the destination -> client copy buffer of tcp sessions to the estimated bandwidth delay product" def:"false"`
+	SessionTokenSecret           string              `desc:"hmac key of the X-Session-Token header linking the websockets of one client session, empty ignores the header" example:"change-me"`
+	ThreatFeedURL                string              `desc:"dnsbl zone or http api client ips are checked against before the websocket upgrade, listed ips get 403" example:"zen.spamhaus.org"`
+	ThreatFeedType               string              `desc:"dnsbl, or http for an api answering a json post of the ip with a listed field" def:"dnsbl"`
+	HappyEyeballs                bool                `desc:"race the ipv6 and ipv4 addresses of tcp destinations, ipv4 starts 250ms after ipv6 (RFC 8305)" def:"false"`
+	SpikeDetectionEnabled        bool                `desc:"throttle users whose traffic of a minute exceeds 3 times their hourly average of the past 7 days" def:"false"`
+	SpikeThrottleMbps            int                 `desc:"bandwidth of a user throttled for a traffic spike in megabits per second" def:"1"`
+	SpikeThrottleDurationSecond  int                 `desc:"seconds a traffic spike throttle lasts" def:"600"`
+	ErrorBatchIntervalSecond     int                 `desc:"seconds dial errors are collected before one report per target is posted to AlertWebhookURL, 0 disables the reports" def:"0"`
+	MaxProxyHops                 int                 `desc:"reject websocket requests whose X-Forwarded-For lists more proxies than this with 421, 0 disables" def:"0"`
+	MaxConnectionCredits         int                 `desc:"websocket connections a user may open in a burst, one credit refills per minute, 0 disables the budget" def:"0"`
+	UpstreamRegions              map[string]string   `desc:"country codes of UpstreamServers, comma separated, clients from these countries prefer the server, the country is the CF-IPCountry header"`
+	SessionResumption            bool                `desc:"hand out X-Session-ID on upgrade, a client reconnecting within 5 minutes with X-Resume-Session keeps its byte counters, is billed as one connection with the session it resumes and spends no connection credit" def:"false"`
+	DiagnosticUUID               string              `desc:"uuid whose VLESS sessions are echoed back by the node instead of dialing the target, for connectivity tests with any client" example:"00000000-0000-4000-8000-000000000000"`
+	LatencyRouting               bool                `desc:"choose among UpstreamServers the one with the lowest first byte latency to the target host instead of hashing, a tenth of the sessions explore the others" def:"false"`
+	AntiTimingNoise              bool                `desc:"send random sized dummy frames to every websocket client to blur the timing of its traffic" def:"false"`
+	NoiseIntervalMs              int                 `desc:"mean milliseconds between two dummy frames of AntiTimingNoise, each gap varies by 50%" def:"1000"`
+	CertPins                     map[string][]string `desc:"hex sha-256 hashes of the leaf certificates accepted from these hosts when the node dials them with tls"`
+	MigrationEnabled             bool                `desc:"keep the destination of a dropped websocket for MigrationGraceSecond, a client moving to another network takes the session over with the X-Migration-Cookie of the upgrade response and X-Migration-Proof, the hex HMAC-SHA256 of the cookie keyed with its uuid. Each session keeps a 1MB replay of the bytes sent to the client. http/1.x sessions rewritten by StripChunkedEncoding, InjectHeaders or InjectTraceID do not migrate" def:"false"`
+	MigrationGraceSecond         int                 `desc:"how long the destination of a dropped migratable session waits for the client" def:"30"`
+	GitHash                      string              `desc:"git hash" def:""`
+	BuildTime                    string              `desc:"build time" def:""`
 }
 
 func (c Config) ListenPort() int {
@@ -41,13 +154,16 @@ var (
 	buildTime string
 )
 
+// ConfigFile is the default config file path, relative to the working directory
+const ConfigFile = "config.toml"
+
 var cfg *Config
 
 func Cfg() *Config {
 	if cfg != nil {
 		return cfg
 	}
-	cfgIns, err := loadFromToml("config.toml")
+	cfgIns, err := loadFromToml(ConfigFile)
 	if err != nil {
 		panic(err)
 	} else {
@@ -58,6 +174,124 @@ func Cfg() *Config {
 	return cfg
 }
 
+// Load reads and validates the config file, used for reloading a running node
+func Load(file string) (*Config, error) {
+	c, err := loadFromToml(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file:%s %w", file, err)
+	}
+	c.GitHash = gitHash
+	c.BuildTime = buildTime
+	return c, nil
+}
+
+func (c Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		return fmt.Errorf("ListenAddr %q: %w", c.ListenAddr, err)
+	}
+	for _, addr := range c.SubAddresses {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("SubAddresses %q: %w", addr, err)
+		}
+	}
+	if c.PeakHoursStart < 0 || c.PeakHoursStart > 23 || c.PeakHoursEnd < 0 || c.PeakHoursEnd > 23 {
+		return fmt.Errorf("PeakHoursStart %d and PeakHoursEnd %d must be within 0-23", c.PeakHoursStart, c.PeakHoursEnd)
+	}
+	switch c.SessionConflictPolicy {
+	case "", "reject_new", "kick_old":
+	default:
+		return fmt.Errorf("SessionConflictPolicy %q: must be reject_new or kick_old", c.SessionConflictPolicy)
+	}
+	switch c.ThreatFeedType {
+	case "", "dnsbl", "http":
+	default:
+		return fmt.Errorf("ThreatFeedType %q: must be dnsbl or http", c.ThreatFeedType)
+	}
+	for _, server := range c.UpstreamServers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			return fmt.Errorf("UpstreamServers %q: %w", server, err)
+		}
+	}
+	for _, relay := range c.OnionRelays {
+		if _, _, err := ParseOnionRelay(relay); err != nil {
+			return fmt.Errorf("OnionRelays %q: %w", relay, err)
+		}
+	}
+	for server := range c.UpstreamRegions {
+		if !slices.Contains(c.UpstreamServers, server) {
+			return fmt.Errorf("UpstreamRegions %q: not one of UpstreamServers", server)
+		}
+	}
+	for host, pins := range c.CertPins {
+		for _, pin := range pins {
+			if b, err := hex.DecodeString(strings.ReplaceAll(pin, ":", "")); err != nil || len(b) != 32 {
+				return fmt.Errorf("CertPins %q: %q is not a hex sha-256 hash", host, pin)
+			}
+		}
+	}
+	for from, to := range c.TargetRewriteRules {
+		if _, _, err := net.SplitHostPort(to); err != nil {
+			return fmt.Errorf("TargetRewriteRules %q: %w", from, err)
+		}
+	}
+	if c.RegisterUrl != "" {
+		if _, err := url.ParseRequestURI(c.RegisterUrl); err != nil {
+			return fmt.Errorf("RegisterUrl %q: %w", c.RegisterUrl, err)
+		}
+	}
+	if c.DiagnosticUUID != "" {
+		if _, err := uuid.Parse(c.DiagnosticUUID); err != nil {
+			return fmt.Errorf("DiagnosticUUID %q: %w", c.DiagnosticUUID, err)
+		}
+	}
+	for _, uid := range c.UserIDS() {
+		if _, err := uuid.Parse(uid); err != nil {
+			return fmt.Errorf("AllowUsers %q: %w", uid, err)
+		}
+	}
+	return nil
+}
+
+// ParseOnionRelay splits a ws(s)://uuid@host/path relay into the websocket url without the user and the VLESS user of the hop
+func ParseOnionRelay(relay string) (*url.URL, uuid.UUID, error) {
+	u, err := url.Parse(relay)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, uuid.Nil, fmt.Errorf("scheme %q: must be ws or wss", u.Scheme)
+	}
+	if u.User == nil {
+		return nil, uuid.Nil, fmt.Errorf("missing the uuid of the hop")
+	}
+	uid, err := uuid.Parse(u.User.Username())
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	u.User = nil
+	return u, uid, nil
+}
+
+// LoadConfigTOML is Load under the name of its format, config files have always been toml
+func LoadConfigTOML(path string) (*Config, error) {
+	return Load(path)
+}
+
+// DumpConfigTOML encodes cfg in the format of config.toml, build info is left out
+func DumpConfigTOML(cfg *Config) ([]byte, error) {
+	c := *cfg
+	c.GitHash = ""
+	c.BuildTime = ""
+	buf := bytes.NewBuffer(nil)
+	if err := toml.NewEncoder(buf).Encode(c); err != nil {
+		return nil, fmt.Errorf("encoding config: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
 func loadFromToml(file string) (*Config, error) {
 	opt := Config{}
 	_, err := toml.DecodeFile(file, &opt)
@@ -101,3 +335,224 @@ func (c Config) PushInterval() time.Duration {
 	}
 	return time.Second * time.Duration(c.PushIntervalSecond)
 }
+
+func (c Config) WriteBufferFlushInterval() time.Duration {
+	if c.WriteBufferFlushMs <= 0 {
+		return time.Millisecond * 5
+	}
+	return time.Millisecond * time.Duration(c.WriteBufferFlushMs)
+}
+
+// IsTLSPassthroughPort reports whether the destination port carries client tls untouched
+func (c Config) IsTLSPassthroughPort(port int) bool {
+	ports := c.TLSPassthroughPorts
+	if ports == nil {
+		ports = []int{443}
+	}
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTLSEgressPort reports whether UseTLSEgress dials the destination port with tls
+func (c Config) IsTLSEgressPort(port int) bool {
+	return slices.Contains(c.TLSEgressPorts, port) && !c.IsTLSPassthroughPort(port)
+}
+
+// DeprecationDeadlineTime parses DeprecationDeadline, ok is false when no deadline is configured
+func (c Config) DeprecationDeadlineTime() (deadline time.Time, ok bool) {
+	if c.DeprecationDeadline == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.DateOnly, c.DeprecationDeadline)
+	if err != nil {
+		log.Println("failed to parse DeprecationDeadline:", err)
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func (c Config) GracefulShutdownTimeout() time.Duration {
+	if c.GracefulShutdownSecond <= 0 {
+		return time.Second * 30
+	}
+	return time.Second * time.Duration(c.GracefulShutdownSecond)
+}
+
+func (c Config) ForceShutdownTimeout() time.Duration {
+	if c.ForceShutdownSecond <= 0 {
+		return time.Second * 5
+	}
+	return time.Second * time.Duration(c.ForceShutdownSecond)
+}
+
+func (c Config) NegativeDNSTTL() time.Duration {
+	if c.NegativeDNSTTLSecond <= 0 {
+		return time.Second * 5
+	}
+	return time.Second * time.Duration(c.NegativeDNSTTLSecond)
+}
+
+func (c Config) MaxDNSTTL() time.Duration {
+	if c.MaxDNSTTLSecond <= 0 {
+		return time.Second * 60
+	}
+	return time.Second * time.Duration(c.MaxDNSTTLSecond)
+}
+
+func (c Config) UpstreamReadBufferSize() int {
+	if c.UpstreamReadBufSize <= 0 {
+		return 8 << 10
+	}
+	return c.UpstreamReadBufSize
+}
+
+func (c Config) DownstreamReadBufferSize() int {
+	if c.DownstreamReadBufSize <= 0 {
+		return 8 << 10
+	}
+	return c.DownstreamReadBufSize
+}
+
+func (c Config) DrainingCloseAfter() time.Duration {
+	if c.DrainingCloseAfterSecond <= 0 {
+		return time.Second * 10
+	}
+	return time.Second * time.Duration(c.DrainingCloseAfterSecond)
+}
+
+func (c Config) DrainNotifyPeriod() time.Duration {
+	if c.DrainNotifyPeriodSecond <= 0 {
+		return 0
+	}
+	return time.Second * time.Duration(c.DrainNotifyPeriodSecond)
+}
+
+func (c Config) StaleTimeout() time.Duration {
+	if c.StaleTimeoutSecond <= 0 {
+		return 0
+	}
+	return time.Second * time.Duration(c.StaleTimeoutSecond)
+}
+
+// SubPath returns the normalized websocket path advertised for subAddr
+func (c Config) SubPath(subAddr, uid string) string {
+	p := c.WebSocketPath
+	if override, ok := c.SubAddressPaths[subAddr]; ok {
+		p = override
+	}
+	if p == "" {
+		p = "/wsv/{uid}"
+	}
+	p = strings.ReplaceAll(p, "{uid}", uid)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	for strings.Contains(p, "//") {
+		p = strings.ReplaceAll(p, "//", "/")
+	}
+	return p
+}
+
+// GlobalBandwidthBytes is GlobalMaxBandwidthMbps in bytes per second
+func (c Config) GlobalBandwidthBytes() int {
+	if c.GlobalMaxBandwidthMbps <= 0 {
+		return 0
+	}
+	return c.GlobalMaxBandwidthMbps * 1000 * 1000 / 8
+}
+
+func (c Config) HandshakeTimeout() time.Duration {
+	if c.HandshakeTimeoutSecond <= 0 {
+		return time.Second * 5
+	}
+	return time.Second * time.Duration(c.HandshakeTimeoutSecond)
+}
+
+// IsPeakHour reports whether t is within [PeakHoursStart, PeakHoursEnd), the period may wrap past midnight
+func (c Config) IsPeakHour(t time.Time) bool {
+	start, end, h := c.PeakHoursStart, c.PeakHoursEnd, t.Hour()
+	if start == end {
+		return false
+	}
+	if start < end {
+		return h >= start && h < end
+	}
+	return h >= start || h < end
+}
+
+// ConnBandwidthBytes is the bandwidth of a connection at t in bytes per second, 0 is unlimited
+func (c Config) ConnBandwidthBytes(t time.Time) int {
+	if c.IsPeakHour(t) {
+		return c.PeakBandwidthKBps * 1024
+	}
+	return c.OffPeakBandwidthKBps * 1024
+}
+
+func (c Config) WriteTimeout() time.Duration {
+	if c.WriteTimeoutSecond <= 0 {
+		return time.Second * 30
+	}
+	return time.Second * time.Duration(c.WriteTimeoutSecond)
+}
+
+func (c Config) MaxSessionDuration() time.Duration {
+	if c.MaxSessionDurationSecond <= 0 {
+		return 0
+	}
+	return time.Second * time.Duration(c.MaxSessionDurationSecond)
+}
+
+func (c Config) AlertCooldown() time.Duration {
+	if c.AlertCooldownSecond <= 0 {
+		return time.Minute * 5
+	}
+	return time.Second * time.Duration(c.AlertCooldownSecond)
+}
+
+func (c Config) TunnelWatchdogInterval() time.Duration {
+	return time.Second * time.Duration(c.TunnelWatchdogIntervalSecond)
+}
+
+func (c Config) TunnelWatchdogTimeout() time.Duration {
+	if c.TunnelWatchdogTimeoutSecond <= 0 {
+		return time.Second * 10
+	}
+	return time.Second * time.Duration(c.TunnelWatchdogTimeoutSecond)
+}
+
+// SpikeThrottleBytes is SpikeThrottleMbps in bytes per second
+func (c Config) SpikeThrottleBytes() int {
+	if c.SpikeThrottleMbps <= 0 {
+		return 1000 * 1000 / 8
+	}
+	return c.SpikeThrottleMbps * 1000 * 1000 / 8
+}
+
+func (c Config) SpikeThrottleDuration() time.Duration {
+	if c.SpikeThrottleDurationSecond <= 0 {
+		return time.Minute * 10
+	}
+	return time.Second * time.Duration(c.SpikeThrottleDurationSecond)
+}
+
+func (c Config) ErrorBatchInterval() time.Duration {
+	return time.Second * time.Duration(c.ErrorBatchIntervalSecond)
+}
+
+func (c Config) NoiseInterval() time.Duration {
+	if c.NoiseIntervalMs <= 0 {
+		return time.Second
+	}
+	return time.Millisecond * time.Duration(c.NoiseIntervalMs)
+}
+
+func (c Config) MigrationGrace() time.Duration {
+	if c.MigrationGraceSecond <= 0 {
+		return time.Second * 30
+	}
+	return time.Second * time.Duration(c.MigrationGraceSecond)
+}
@@ -6,9 +6,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/redis/go-redis/v9"
 	"github.com/unchainese/unchain/internal/global"
+	"golang.org/x/time/rate"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"runtime"
@@ -18,59 +23,320 @@ import (
 )
 
 type App struct {
-	cfg           *global.Config
-	mu            sync.Mutex
-	allowedUsers  map[string]int64
-	trafficUserKB sync.Map
-	reqCount      atomic.Int64
-	svr           *http.Server
-	exitSignal    chan os.Signal
+	cfg               *global.Config
+	mu                sync.Mutex
+	allowedUsers      map[string]UserMeta
+	redisUsers        map[string]UserMeta //the hash of RedisAddr, merged with allowedUsers by lookupUser
+	trafficUserKB     sync.Map
+	reqCount          atomic.Int64
+	svr               *http.Server
+	listener          net.Listener
+	h3                *http3.Server
+	mux               *http.ServeMux
+	upgrader          *websocket.Upgrader
+	handler           http.Handler
+	decoyBody         []byte
+	startedAt         time.Time
+	certs             *certStore
+	exitSignal        chan os.Signal
+	configFile        string   //reloaded on SIGHUP and on changes, empty for none
+	connRegistry      sync.Map //connID -> *connEntry
+	connWG            sync.WaitGroup
+	draining          atomic.Bool
+	subLimiters       sync.Map //ip -> *subLimiter
+	bandwidth         *rate.Limiter
+	pskHash           []byte //bcrypt hash of PSK, nil when it is not set
+	pcap              *pcapDump
+	activeConns       atomic.Int64
+	tfoUnsupported    sync.Once
+	hostHits          sync.Map //host -> *atomic.Int64 lookups, see topHosts
+	prefetchQueue     chan string
+	redis             *redis.Client //nil unless RedisAddr is set
+	stormOnce         sync.Once
+	stormLimiter      *rate.Limiter
+	acceptBackoff     atomic.Int64 //nanoseconds, see stormBackoff
+	pushClient        *http.Client
+	dnsResponses      *dnsResponseCache
+	dnsCache          sync.Map //host -> *dnsCacheEntry
+	resolver          *net.Resolver
+	dnsTTLs           *dnsTTLRecorder //answer ttls read by resolver
+	hosts             hostsFileResolver
+	protocolStats     sync.Map //protocol -> *atomic.Int64
+	rewriteStats      sync.Map //rewritten host:port -> *atomic.Int64
+	clientStats       sync.Map //X-Client-Version -> *atomic.Int64
+	trafficClasses    sync.Map //class -> *atomic.Int64
+	tagStats          sync.Map //?tag= of WsVLESS -> *atomic.Int64
+	pollSessions      sync.Map //session secret -> *pollSession
+	dialWindows       sync.Map //target host:port -> *dialWindow
+	threatCache       sync.Map //client ip -> threatEntry
+	credits           sync.Map //uid -> *ConnectionCredits
+	resumable         sync.Map //session id -> *resumableSession
+	migrations        sync.Map //migration cookie -> *migratingSession
+	costMu            sync.Mutex
+	costTargets       map[string]struct{} //targets since the last stat, see scoreConnection
+	costSum           float64
+	costN             int64
+	latencyMu         sync.Mutex
+	latencyMatrix     map[string]map[string]*LatencyStats //upstream -> target host -> latency
+	errorBatchMu      sync.Mutex
+	errorBatch        map[errorBatchKey]int //dial errors since the last report
+	sniMismatch       atomic.Int64
+	zstdMu            sync.Mutex
+	zstd              *zstdCodec
+	compressedBytes   atomic.Int64
+	billingMu         sync.Mutex
+	usage             map[string]*userUsage //uid -> traffic of the month
+	freeBytes         map[string]int64
+	billedBytes       map[string]int64
+	spikeMu           sync.Mutex
+	trafficHistory    map[string]*trafficHistory //uid -> KB of the past 7 days
+	spikeLimited      map[string]*spikeState
+	uncompressedBytes atomic.Int64
+	noiseBytes        atomic.Int64 //see AntiTimingNoise
+	memMu             sync.Mutex
+	memReadAt         time.Time
+	memPressure       bool
+	bufferPools       sync.Map   //size -> *sync.Pool of *[]byte
+	sessionMu         sync.Mutex //claims and releases of activeSessionIPs are read-modify-write
+	activeSessionIPs  sync.Map   //uid -> *userSession
+	targetLatency     sync.Map   //host -> *latencyRing
+	logger            *slog.Logger
+	deprecations      sync.Map //endpoint -> *deprecation
+
+	statMu         sync.Mutex
+	lastStatAt     time.Time
+	lastStatResult *AppStat
 }
 
-func (app *App) httpSvr() {
+func (app *App) routes() {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/wsv/{uid}", app.WsVLESS)
-	mux.HandleFunc("/sub/{uid}", app.Sub)
-	mux.HandleFunc("/ws-vless", app.WsVLESS)
+	mux.HandleFunc("/sub/{uid}", app.subRateLimit(app.Sub))
+	mux.HandleFunc("GET /sub/{uid}/qr", app.subRateLimit(app.SubQRPage))
+	mux.HandleFunc("/ws-vless", app.deprecated("/ws-vless", "/wsv/{uid}", app.WsVLESS))
+	mux.HandleFunc("/bridge/{uid}/{host}/{port}", app.WsBridge)
+	mux.HandleFunc("POST /xh/{uid}", app.WsXHTTP)
+	mux.HandleFunc("GET /admin/connections", app.adminAuth(app.AdminConnections))
+	mux.HandleFunc("PUT /admin/connections/{connID}/meta", app.adminAuth(app.AdminConnectionMeta))
+	mux.HandleFunc("GET /admin/sessions/{token}", app.adminAuth(app.AdminSession))
+	mux.HandleFunc("GET /admin/cmd", app.AdminCmd)
 	mux.HandleFunc("/", app.Ping)
+	app.mux = mux
+	app.handler = app.probeFacade(mux)
+}
+
+// ServeHTTP makes App a http.Handler, so it can be mounted in another server or httptest.NewServer
+func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	app.handler.ServeHTTP(w, r)
+}
+
+func (app *App) httpSvr() error {
+	app.routes()
+	handler := app.handler
+	if app.config().UseSelfAsHandler {
+		handler = app
+	}
+	c := app.config()
+	if c.UseQUIC {
+		if err := app.quicSvr(); err != nil {
+			return err
+		}
+		handler = app.altSvc(handler)
+	}
 	server := &http.Server{
-		Addr:    app.cfg.ListenAddr,
-		Handler: mux,
+		Addr:    c.ListenAddr,
+		Handler: handler,
+	}
+	if c.ProbeResistance {
+		body, err := loadDecoyBody(c.DecoyResponseFile)
+		if err != nil {
+			return err
+		}
+		app.decoyBody = body
+	}
+	certFile, keyFile := c.TLSCertFile, c.TLSKeyFile
+	if certFile == "" && c.ProbeResistance {
+		certFile, keyFile = c.DecoyTLSCertFile, c.DecoyTLSKeyFile
+	}
+	if certFile != "" {
+		certs, err := newCertStore(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		app.certs = certs
+		server.TLSConfig = app.tlsConfig()
+		if c.ManualCertRotation {
+			go app.loopCertReload()
+		}
 	}
 	app.svr = server
+	return nil
+}
 
+// AppOption configures an App built by NewApp
+type AppOption func(*App)
+
+func WithConfig(c *global.Config) AppOption {
+	return func(app *App) {
+		app.cfg = c
+	}
+}
+
+// WithConfigFile sets the file the config is reloaded from on SIGHUP and on changes,
+// without it the config of WithConfig is never reloaded
+func WithConfigFile(path string) AppOption {
+	return func(app *App) {
+		app.configFile = path
+	}
+}
+
+// WithSignalChannel sets the channel the os signals are delivered to, the last push happens on exit
+func WithSignalChannel(ch chan os.Signal) AppOption {
+	return func(app *App) {
+		app.exitSignal = ch
+	}
+}
+
+func WithLogger(l *slog.Logger) AppOption {
+	return func(app *App) {
+		app.logger = l
+	}
+}
+
+// WithHTTPClient sets the client PushNode talks to the register server with, eg. one with a mocked transport
+func WithHTTPClient(c *http.Client) AppOption {
+	return func(app *App) {
+		app.pushClient = c
+	}
 }
 
-func NewApp(c *global.Config, sig chan os.Signal) *App {
+func NewApp(opts ...AppOption) (*App, error) {
 	app := &App{
-		cfg:           c,
 		mu:            sync.Mutex{},
-		allowedUsers:  make(map[string]int64),
+		allowedUsers:  make(map[string]UserMeta),
 		trafficUserKB: sync.Map{},
 		reqCount:      atomic.Int64{},
-		exitSignal:    sig,
 		svr:           nil,
+		logger:        slog.Default(),
+		dnsTTLs:       newDNSTTLRecorder(),
+		pushClient:    &http.Client{Timeout: time.Second * 30},
+		startedAt:     time.Now(),
 	}
-	for _, userID := range c.UserIDS() {
-		app.allowedUsers[userID] = 1
+	app.resolver = newTTLResolver(app.dnsTTLs)
+	for _, opt := range opts {
+		opt(app)
+	}
+	if app.cfg == nil {
+		return nil, errors.New("node app requires a config, use WithConfig")
+	}
+	for _, userID := range app.cfg.UserIDS() {
+		app.allowedUsers[userID] = UserMeta{Value: 1}
+	}
+	app.upgrader = newUpgrader(app.cfg)
+	app.bandwidth = newBandwidthLimiter(app.cfg.GlobalBandwidthBytes())
+	pskHash, err := hashPSK(app.cfg.PSK)
+	if err != nil {
+		return nil, fmt.Errorf("hashing PSK: %w", err)
+	}
+	app.pskHash = pskHash
+	if app.cfg.EnableECN {
+		if ecnEnabled() {
+			app.logger.Info("ecn enabled for destinations")
+		} else {
+			app.logger.Warn("ecn is not requested on outgoing connections, set sysctl net.ipv4.tcp_ecn=1")
+		}
+	}
+	if app.cfg.UsePostQuantumKEM {
+		if postQuantumKEM == "" {
+			app.logger.Warn("post quantum key exchange unavailable, the node was built with go older than 1.23")
+		} else {
+			app.logger.Info("post quantum key exchange enabled for tls clients", "kem", postQuantumKEM)
+		}
+	}
+	if app.cfg.UseMPTCP {
+		app.logger.Info("multipath tcp enabled for destinations", "available", mptcpAvailable())
+	}
+	if path := app.cfg.DebugPCAPPath; path != "" {
+		if app.pcap, err = newPCAPDump(path); err != nil {
+			return nil, err
+		}
+		app.logger.Warn("capturing the cleartext of all tcp sessions, do not use in production", "file", path)
+	}
+	app.loadHosts()
+	if n := app.cfg.DNSCacheMaxEntries; n > 0 {
+		app.dnsResponses = newDNSResponseCache(n)
+	}
+	if err := app.httpSvr(); err != nil {
+		return nil, err
 	}
-	app.httpSvr()
 	go app.loopPush()
+	if app.configFile != "" {
+		go app.loopReload(app.configFile)
+		go app.loopWatchConfig(app.configFile)
+	}
+	go app.loopPruneSubLimiters()
+	go app.loopPruneCredits()
+	go app.loopPruneResumable()
+	go app.loopSweepDNSCache()
+	go app.loopBandwidth()
+	if app.cfg.ErrorBatchIntervalSecond > 0 {
+		go app.loopErrorBatch()
+	}
+	if app.cfg.DNSPrefetchTopN > 0 {
+		app.prefetchQueue = make(chan string, app.cfg.DNSPrefetchTopN)
+		go app.loopDNSPrefetch()
+	}
+	if app.redis = newRedisClient(app.cfg); app.redis != nil {
+		go app.loopRedisUsers()
+	}
+	if app.cfg.GracefulRestartEnabled {
+		go app.loopRestart()
+	}
+	return app, nil
+}
+
+// NewAppFromConfig is the constructor before AppOption existed
+//
+// Deprecated: use NewApp(WithConfig(c), WithSignalChannel(sig))
+func NewAppFromConfig(c *global.Config, sig chan os.Signal) *App {
+	app, err := NewApp(WithConfig(c), WithSignalChannel(sig))
+	if err != nil {
+		log.Fatalln(err)
+	}
 	return app
 }
 
 func (app *App) Run() {
-	log.Println("server starting on http://", app.cfg.ListenAddr)
-	if err := app.svr.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatalf("Could not listen on %s: %v\n", app.cfg.ListenAddr, err)
+	ln, err := app.listen()
+	if err != nil {
+		log.Fatalf("Could not listen on %s: %v\n", app.config().ListenAddr, err)
+	}
+	app.mu.Lock()
+	app.listener = ln
+	app.mu.Unlock()
+	if app.h3 != nil {
+		go app.runQUIC()
+	}
+	if app.svr.TLSConfig != nil {
+		log.Println("server starting on https://", app.config().ListenAddr)
+		err = app.svr.ServeTLS(ln, "", "")
+	} else {
+		log.Println("server starting on http://", app.config().ListenAddr)
+		err = app.svr.Serve(ln)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Could not listen on %s: %v\n", app.config().ListenAddr, err)
 	}
 }
 
 func (app *App) PrintVLESSConnectionURLS() {
-	listenPort := app.cfg.ListenPort()
+	listenPort := app.config().ListenPort()
 
 	fmt.Printf("\n\n\nvist to get VLESS connection info: http://127.0.0.1:%d/sub/<YOUR_CONFIGED_UUID> \n", listenPort)
 	fmt.Printf("vist to get VLESS connection info: http://<HOST>:%d/sub/<YOUR_UUID>\n", listenPort)
+	if app.h3 != nil {
+		fmt.Printf("subscriptions are also served over http/3 (quic): https://<HOST>:%d/sub/<YOUR_UUID>\n", listenPort)
+	}
 
 	for userID, _ := range app.allowedUsers {
 		fmt.Println("\n------------- USER UUID:  ", userID, " -------------")
@@ -82,30 +348,88 @@ func (app *App) PrintVLESSConnectionURLS() {
 	fmt.Println("\n\n\n")
 }
 
+// Shutdown stops accepting requests and waits GracefulShutdownTimeout for the active connections,
+// the remaining ones are closed and given ForceShutdownTimeout to return.
 func (app *App) Shutdown(ctx context.Context) {
 	log.Println("Shutting down the server...")
-	if err := app.svr.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+	cfg := app.config()
+	app.StartDraining()
+	if d := cfg.DrainNotifyPeriod(); d > 0 {
+		log.Println("Draining, new connections are told to reconnect elsewhere for", d)
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+		}
+	}
+	graceCtx, cancel := context.WithTimeout(ctx, cfg.GracefulShutdownTimeout())
+	defer cancel()
+	if err := app.svr.Shutdown(graceCtx); err != nil {
+		log.Println("Server graceful shutdown incomplete:", err)
+	}
+	if app.h3 != nil {
+		if err := app.h3.Close(); err != nil {
+			log.Println("Error closing quic server:", err)
+		}
+	}
+	// websocket connections are hijacked, http.Server.Shutdown doesn't wait for them
+	if waitTimeout(&app.connWG, graceCtx) {
+		log.Println("Server exiting")
+		return
+	}
+	n := app.closeConnections()
+	app.svr.Close()
+	log.Printf("Server forced to shutdown, closed %d connections\n", n)
+	forceCtx, forceCancel := context.WithTimeout(ctx, cfg.ForceShutdownTimeout())
+	defer forceCancel()
+	if !waitTimeout(&app.connWG, forceCtx) {
+		log.Println("Server exiting with connections still running")
+		return
 	}
 	log.Println("Server exiting")
 }
 
+// StartDraining marks new websocket upgrades with X-Server-Closing and closes them after DrainingCloseAfter,
+// so clients move to other nodes before the shutdown.
+func (app *App) StartDraining() {
+	if !app.draining.Swap(true) {
+		log.Println("Server draining")
+	}
+}
+
+// waitTimeout waits for wg, it returns false when ctx is done first
+func waitTimeout(wg *sync.WaitGroup, ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
 func (app *App) loopPush() {
-	url := app.cfg.RegisterUrl
+	url := app.config().RegisterUrl
 	if url == "" {
 		log.Println("Register url is empty, skip register, runs in standalone mode")
 		return
 	}
-	tk := time.NewTicker(app.cfg.PushInterval())
+	tk := time.NewTicker(app.config().PushInterval())
 	defer tk.Stop()
 	for {
 		select {
 		case sig := <-app.exitSignal:
 			app.exitSignal <- sig
-			app.PushNode() //last push
+			ctx, cancel := context.WithTimeout(context.Background(), app.config().GracefulShutdownTimeout())
+			app.pushNode(ctx) //last push
+			cancel()
 			return
 		case <-tk.C:
 			app.PushNode()
+			tk.Reset(app.config().PushInterval()) // PushIntervalSecond may have been reloaded
 		}
 	}
 }
@@ -115,7 +439,22 @@ func (app *App) reqInc() {
 }
 
 func (app *App) trafficInc(uid string, byteN int64) {
-	kb := byteN/1024 + 1 //floor
+	app.trafficAdd(uid, byteN, byteN/1024+1) //floor
+}
+
+// trafficAdd adds kb to the traffic of uid, byteN are the bytes behind it for the billing and spike detection
+func (app *App) trafficAdd(uid string, byteN, kb int64) {
+	if byteN > 0 {
+		if free := app.config().FreeBytesPerUser; free > 0 {
+			app.billTraffic(uid, byteN, free)
+		}
+		if app.config().SpikeDetectionEnabled {
+			app.detectSpike(uid, byteN/1024)
+		}
+	}
+	if kb <= 0 {
+		return
+	}
 	value, ok := app.trafficUserKB.Load(uid)
 	if !ok {
 		app.trafficUserKB.Store(uid, kb)
@@ -124,28 +463,81 @@ func (app *App) trafficInc(uid string, byteN int64) {
 	app.trafficUserKB.Store(uid, value.(int64)+kb)
 }
 
+// statMinInterval is how long a stat snapshot is reused before the counters are collected again
+const statMinInterval = time.Second
+
+// stat returns the cached snapshot when called again within statMinInterval,
+// so rapid readers don't reset the traffic counters between pushes.
 func (app *App) stat() *AppStat {
+	app.statMu.Lock()
+	defer app.statMu.Unlock()
+	if app.lastStatResult != nil && time.Since(app.lastStatAt) < statMinInterval {
+		return app.lastStatResult
+	}
+	return app.statLocked()
+}
+
+// statForce collects and resets the counters regardless of the last snapshot
+func (app *App) statForce() *AppStat {
+	app.statMu.Lock()
+	defer app.statMu.Unlock()
+	return app.statLocked()
+}
+
+func (app *App) statLocked() *AppStat {
+	cfg := app.config()
 	data := make(map[string]int64)
 	app.trafficUserKB.Range(func(key, value interface{}) bool {
 		data[key.(string)] = value.(int64)
+		app.trafficUserKB.Delete(key) //sync.Map.Clear needs go 1.23
 		return true
 	})
-	app.trafficUserKB.Clear()
+	if app.redis != nil {
+		go app.pushRedisTraffic(data)
+	}
 
 	hostname, err := os.Hostname()
 	if err != nil {
 		hostname = "unknown"
-		slog.Error(err.Error())
+		app.logger.Error(err.Error())
 	}
+	freeKB, billedKB := app.drainBilling()
+	costTotal, costAvg := app.drainConnectionCost()
 	res := &AppStat{
 		Traffic:     data,
 		Hostname:    hostname,
 		ReqCount:    app.reqCount.Load(),
 		Goroutine:   int64(runtime.NumGoroutine()),
-		VersionInfo: app.cfg.GitHash + " -> " + app.cfg.BuildTime,
+		VersionInfo: cfg.GitHash + " -> " + cfg.BuildTime,
+		NodeTags:    cfg.NodeTags,
+
+		DeprecationWarnings: app.deprecationWarnings(),
+		ProtocolStats:       counterDrain(&app.protocolStats),
+		NetworkInterfaces:   app.networkInterfaces(),
+
+		TargetLatency: app.drainTargetLatency(),
+		RewriteCount:  counterDrain(&app.rewriteStats),
+
+		ClientVersionStats: counterDrain(&app.clientStats),
+		TrafficClasses:     counterDrain(&app.trafficClasses),
+		SNIMismatchCount:   app.sniMismatch.Swap(0),
+		TagStats:           counterDrain(&app.tagStats),
+		TargetErrorRates:   app.targetErrorRates(),
+
+		CompressedBytesKB:   app.compressedBytes.Swap(0) / 1024,
+		UncompressedBytesKB: app.uncompressedBytes.Swap(0) / 1024,
+
+		TrafficFreeKB:   freeKB,
+		TrafficBilledKB: billedKB,
+
+		AvgConnectionScore:   costAvg,
+		TotalConnectionScore: costTotal,
+		TotalNoiseKB:         app.noiseBytes.Swap(0) / 1024,
 	}
-	res.SubAddresses = app.cfg.SubAddresses
+	res.SubAddresses = cfg.SubAddresses
 	app.reqCount.Store(0)
+	app.lastStatAt = time.Now()
+	app.lastStatResult = res
 	return res
 }
 
@@ -156,14 +548,43 @@ type AppStat struct {
 	ReqCount     int64            `json:"req_count"`
 	Goroutine    int64            `json:"goroutine"`
 	VersionInfo  string           `json:"version_info"`
+	NodeTags     []string         `json:"node_tags"`
+
+	DeprecationWarnings map[string]time.Time `json:"deprecation_warnings"`
+	ProtocolStats       map[string]int64     `json:"protocol_stats"`
+	NetworkInterfaces   []NetworkIface       `json:"network_interfaces"`
+
+	TargetLatency map[string]*LatencyBucket `json:"target_latency"`
+	RewriteCount  map[string]int64          `json:"rewrite_count"`
+
+	ClientVersionStats map[string]int64   `json:"client_version_stats"`
+	TrafficClasses     map[string]int64   `json:"traffic_classes"`
+	SNIMismatchCount   int64              `json:"sni_mismatch_count"`
+	TagStats           map[string]int64   `json:"tag_stats"`
+	TargetErrorRates   map[string]float64 `json:"target_error_rates"`
+
+	CompressedBytesKB   int64 `json:"compressed_bytes_kb"`
+	UncompressedBytesKB int64 `json:"uncompressed_bytes_kb"`
+
+	TrafficFreeKB   map[string]int64 `json:"traffic_free_kb"`
+	TrafficBilledKB map[string]int64 `json:"traffic_billed_kb"`
+
+	AvgConnectionScore   float64 `json:"avg_connection_score"`
+	TotalConnectionScore float64 `json:"total_connection_score"`
+	TotalNoiseKB         int64   `json:"total_noise_kb"`
 }
 
 func (app *App) PushNode() {
-	url := app.cfg.RegisterUrl
+	app.pushNode(context.Background())
+}
+
+func (app *App) pushNode(ctx context.Context) {
+	cfg := app.config()
+	url := cfg.RegisterUrl
 	if url == "" {
 		return
 	}
-	args := app.stat()
+	args := app.statForce() //every push must carry the counters exactly once
 	body := bytes.NewBuffer(nil)
 	err := json.NewEncoder(body).Encode(args)
 	if err != nil {
@@ -171,35 +592,57 @@ func (app *App) PushNode() {
 		return
 	}
 
-	req, err := http.NewRequest("POST", url, body)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
 	if err != nil {
 		log.Println("Error registering:", err)
 		return
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", app.cfg.RegisterToken)
+	req.Header.Set("Authorization", cfg.RegisterToken)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := app.pushClient.Do(req)
 	if err != nil {
 		log.Println("Error registering:", err)
 		return
 	}
 	defer resp.Body.Close()
-	users := make(map[string]int64)
+	users := make(map[string]UserMeta)
 	err = json.NewDecoder(resp.Body).Decode(&users)
 	if err != nil {
 		log.Println("Error decoding response:", err)
 		return
 	}
 	app.mu.Lock()
+	defer app.mu.Unlock()
+	if len(users) < cfg.MinAllowedUsers && len(app.allowedUsers) > 0 {
+		// an empty map from a broken register server would disconnect every user
+		app.logger.Warn("rejecting push response, too few users", "users", len(users), "min", cfg.MinAllowedUsers, "current", len(app.allowedUsers))
+		return
+	}
 	app.allowedUsers = users
-	app.mu.Unlock()
+}
+
+// lookupUser is the meta of uid from the pushed users or the redis hash, the pushed meta wins.
+// app.mu must be held.
+func (app *App) lookupUser(uid string) (UserMeta, bool) {
+	if meta, ok := app.allowedUsers[uid]; ok {
+		return meta, true
+	}
+	meta, ok := app.redisUsers[uid]
+	return meta, ok
+}
+
+// config returns the current config, which may be swapped by reloadConfig
+func (app *App) config() *global.Config {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	return app.cfg
 }
 
 func (app *App) IsUserNotAllowed(uuid string) (isNotAllowed bool) {
 	app.mu.Lock()
 	defer app.mu.Unlock()
-	_, ok := app.allowedUsers[uuid]
+	_, ok := app.lookupUser(uuid)
 	if !ok {
 		log.Println("Unauthorized user:", uuid)
 		return true
@@ -0,0 +1,61 @@
+package node
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// adminAuth guards the admin endpoints with the AdminToken from config,
+// the endpoints don't exist when no token is configured.
+func (app *App) adminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := app.config().AdminToken
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (app *App) AdminConnections(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(app.Connections())
+}
+
+// AdminConnectionMeta sets the metadata of an active connection from a json object of string values
+func (app *App) AdminConnectionMeta(w http.ResponseWriter, r *http.Request) {
+	connID := r.PathValue("connID")
+	meta := make(map[string]string)
+	if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for key, value := range meta {
+		err := app.SetConnectionMeta(connID, key, value)
+		if errors.Is(err, errConnNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminSession lists the active connections sharing an X-Session-Token
+func (app *App) AdminSession(w http.ResponseWriter, r *http.Request) {
+	list := app.SessionConnections(r.PathValue("token"))
+	if len(list) == 0 {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(list)
+}
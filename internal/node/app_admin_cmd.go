@@ -0,0 +1,161 @@
+package node
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/unchainese/unchain/internal/schema"
+)
+
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcConnNotFound   = -32001
+
+	messageAddonKey = "message"
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// AdminCmd is a JSON-RPC 2.0 command channel over websocket, the first message must be the AdminToken.
+// Methods: close_connection {conn_id}, get_connection_stats {conn_id}, broadcast_message {uuid, message}.
+func (app *App) AdminCmd(w http.ResponseWriter, r *http.Request) {
+	token := app.config().AdminToken
+	if token == "" {
+		http.NotFound(w, r)
+		return
+	}
+	ws, err := app.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		app.logger.Error("Error upgrading to websocket:", "err", err)
+		return
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, first, err := ws.ReadMessage()
+	if err != nil || subtle.ConstantTimeCompare(first, []byte(token)) != 1 {
+		closeWs(ws, websocket.ClosePolicyViolation, "unauthorized")
+		return
+	}
+	ws.SetReadDeadline(time.Time{})
+	app.logger.Info("admin command channel opened", "remote_ip", realIP(r))
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		res := app.adminRPC(msg)
+		if err := ws.WriteJSON(res); err != nil {
+			return
+		}
+	}
+}
+
+func (app *App) adminRPC(msg []byte) rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: rpcParseError, Message: err.Error()}}
+	}
+	res := rpcResponse{JSONRPC: "2.0", ID: req.ID}
+	var params struct {
+		ConnID  string `json:"conn_id"`
+		UUID    string `json:"uuid"`
+		Message string `json:"message"`
+	}
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			res.Error = &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+			return res
+		}
+	}
+	var err error
+	switch req.Method {
+	case "close_connection":
+		err = app.CloseConnection(params.ConnID)
+		res.Result = "ok"
+	case "get_connection_stats":
+		res.Result, err = app.ConnectionStats(params.ConnID)
+	case "broadcast_message":
+		if _, ok := messageFrame(0, params.Message); !ok {
+			res.Error = &rpcError{Code: rpcInvalidParams, Message: "message too long for a vless addons frame"}
+			return res
+		}
+		res.Result = map[string]int{"delivered": app.BroadcastMessage(params.UUID, params.Message)}
+	default:
+		res.Error = &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + req.Method}
+		return res
+	}
+	if errors.Is(err, errConnNotFound) {
+		res.Result = nil
+		res.Error = &rpcError{Code: rpcConnNotFound, Message: err.Error()}
+	}
+	return res
+}
+
+// CloseConnection terminates an active connection
+func (app *App) CloseConnection(connID string) error {
+	v, ok := app.connRegistry.Load(connID)
+	if !ok {
+		return errConnNotFound
+	}
+	e := v.(*connEntry)
+	e.setCloseReason(closeAdminClosed)
+	app.logger.Info("closing connection by admin command", "conn_id", connID)
+	return e.close()
+}
+
+func (app *App) ConnectionStats(connID string) (ConnectionInfo, error) {
+	v, ok := app.connRegistry.Load(connID)
+	if !ok {
+		return ConnectionInfo{}, errConnNotFound
+	}
+	return v.(*connEntry).info(), nil
+}
+
+// BroadcastMessage sends message to every VLESS tcp session of uid as a frame of the response header
+// form, version and addons with the message under messageAddonKey. It returns the sessions reached.
+func (app *App) BroadcastMessage(uid, message string) int {
+	n := 0
+	app.connRegistry.Range(func(_, value any) bool {
+		e := value.(*connEntry)
+		e.mu.Lock()
+		owner, write := e.uid, e.message
+		e.mu.Unlock()
+		if owner == uid && write != nil && write(message) == nil {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// messageFrame is the VLESS frame carrying text, false when the addons outgrow their length byte
+func messageFrame(version byte, text string) ([]byte, bool) {
+	addons := schema.VlessAddons{Meta: map[string]string{messageAddonKey: text}}.Marshal()
+	if len(addons) > 0xff {
+		return nil, false
+	}
+	return append([]byte{version, byte(len(addons))}, addons...), true
+}
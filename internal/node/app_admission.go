@@ -0,0 +1,107 @@
+package node
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	errUserNotAllowed   = errors.New("user not allowed")
+	errCreditsExhausted = errors.New("connection credits exhausted")
+	errServerBusy       = errors.New("server busy")
+	errSessionConflict  = errors.New("user connected from another ip")
+)
+
+// admitRequest runs the checks every transport passes before it reads the VLESS request:
+// the psk, MaxProxyHops, the threat feed and the memory and bandwidth breakers.
+// A rejected request is answered with its http error.
+func (app *App) admitRequest(w http.ResponseWriter, r *http.Request) bool {
+	if !app.pskAllowed(r) {
+		// the same response for a wrong key and a missing one
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	if limit := app.config().MaxProxyHops; limit > 0 {
+		if hops := forwardedHops(r); len(hops) > 0 {
+			app.logger.Debug("x-forwarded-for chain", "hops", hops, "remote_ip", realIP(r))
+			if len(hops) > limit {
+				http.Error(w, "Misdirected Request", http.StatusMisdirectedRequest)
+				return false
+			}
+		}
+	}
+	if app.ipListed(r.Context(), realIP(r)) {
+		app.logger.Warn("rejecting client listed by the threat feed", "remote_ip", realIP(r))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	if app.memoryPressure() {
+		w.Header().Set("X-Pressure", "memory")
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return false
+	}
+	if app.bandwidthExhausted() {
+		app.logger.Warn("rejecting connection, global bandwidth exhausted", "remote_ip", realIP(r))
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// admitUser runs the per user checks of a parsed VLESS request: the allowed users, the connection credits,
+// the admission under pressure and SingleSessionPerUser. A new connection spends a credit, a resumed or
+// migrated one does not. release must be called once the connection ends.
+func (app *App) admitUser(uid, remoteIP, connID string, newConn bool, logger *slog.Logger) (release func(), err error) {
+	if app.IsUserNotAllowed(uid) {
+		// users are dropped from the allowed list once their traffic is used up
+		logger.Info("connection closed", "reason", closeQuotaExceeded, "uid", uid)
+		return nil, errUserNotAllowed
+	}
+	if app.creditsExhausted(uid) {
+		logger.Warn("connection credits exhausted, throttling user", "uid", uid)
+		return nil, errCreditsExhausted
+	}
+	if newConn {
+		app.spendConnectionCredit(uid)
+	}
+	if !app.admitConnection(uid) {
+		logger.Warn("rejecting connection under pressure", "uid", uid, "active", app.activeConns.Load())
+		return nil, errServerBusy
+	}
+	if app.config().SingleSessionPerUser {
+		if !app.claimSession(uid, remoteIP, connID) {
+			return nil, errSessionConflict
+		}
+		return func() { app.releaseSession(uid, connID) }, nil
+	}
+	return func() {}, nil
+}
+
+// admissionStatus is the http status of an admitUser error
+func admissionStatus(err error) int {
+	switch {
+	case errors.Is(err, errUserNotAllowed):
+		return http.StatusUnauthorized
+	case errors.Is(err, errCreditsExhausted):
+		return http.StatusTooManyRequests
+	case errors.Is(err, errSessionConflict):
+		return http.StatusConflict
+	default:
+		return http.StatusServiceUnavailable
+	}
+}
+
+// closeWsAdmission closes the websocket of a connection admitUser rejected
+func closeWsAdmission(ws *websocket.Conn, err error) {
+	switch {
+	case errors.Is(err, errUserNotAllowed):
+		ws.Close()
+	case errors.Is(err, errSessionConflict):
+		closeWs(ws, websocket.ClosePolicyViolation, "")
+	default:
+		closeWs(ws, websocket.CloseTryAgainLater, err.Error())
+	}
+}
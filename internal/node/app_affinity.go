@@ -0,0 +1,41 @@
+package node
+
+import (
+	"context"
+	"hash/fnv"
+	"net"
+	"strconv"
+)
+
+func withRemoteIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, ctxKeyRemoteIP, ip)
+}
+
+func remoteIPFrom(ctx context.Context) string {
+	ip, _ := ctx.Value(ctxKeyRemoteIP).(string)
+	return ip
+}
+
+// pickUpstream returns the server of key by rendezvous hashing, a key keeps its server as long as
+// that server is configured and removing a server only moves the keys that were on it
+func pickUpstream(servers []string, key string) string {
+	var best string
+	var bestScore uint64
+	for _, server := range servers {
+		h := fnv.New64a()
+		h.Write([]byte(server))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		if score := h.Sum64(); best == "" || score > bestScore {
+			best, bestScore = server, score
+		}
+	}
+	return best
+}
+
+// splitUpstream splits a server of UpstreamServers, Validate has checked the format
+func splitUpstream(server string) (string, int) {
+	host, portStr, _ := net.SplitHostPort(server)
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
@@ -0,0 +1,111 @@
+package node
+
+import (
+	"context"
+	"github.com/unchainese/unchain/internal/global"
+	"golang.org/x/time/rate"
+	"io"
+	"sync"
+	"time"
+)
+
+// newBandwidthLimiter returns a token bucket of bytesPerSecond,
+// it never blocks when bytesPerSecond is 0.
+func newBandwidthLimiter(bytesPerSecond int) *rate.Limiter {
+	l := rate.NewLimiter(rate.Inf, 0)
+	setBandwidth(l, bytesPerSecond)
+	return l
+}
+
+func setBandwidth(l *rate.Limiter, bytesPerSecond int) {
+	if bytesPerSecond <= 0 {
+		l.SetLimit(rate.Inf)
+		return
+	}
+	l.SetLimit(rate.Limit(bytesPerSecond))
+	l.SetBurst(bytesPerSecond)
+}
+
+// waitLimiter blocks until l allows n bytes
+func waitLimiter(ctx context.Context, l *rate.Limiter, n int) error {
+	if l.Limit() == rate.Inf {
+		return nil
+	}
+	for n > 0 {
+		// WaitN fails when asked for more than the burst
+		chunk := min(n, l.Burst())
+		if err := l.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// bandwidthExhausted reports whether the shared bucket is empty, new connections are rejected then
+func (app *App) bandwidthExhausted() bool {
+	return app.bandwidth.Limit() != rate.Inf && app.bandwidth.Tokens() < 1
+}
+
+// periodLimiter is the bandwidth of one connection, its rate follows the peak and off-peak hours
+type periodLimiter struct {
+	cfg     *global.Config
+	now     func() time.Time
+	mu      sync.Mutex
+	peak    bool
+	limiter *rate.Limiter
+}
+
+func newPeriodLimiter(cfg *global.Config, now func() time.Time) *periodLimiter {
+	t := now()
+	return &periodLimiter{
+		cfg:     cfg,
+		now:     now,
+		peak:    cfg.IsPeakHour(t),
+		limiter: newBandwidthLimiter(cfg.ConnBandwidthBytes(t)),
+	}
+}
+
+// current returns the limiter, updating its rate when a period boundary was crossed
+func (p *periodLimiter) current() *rate.Limiter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := p.now()
+	if peak := p.cfg.IsPeakHour(t); peak != p.peak {
+		p.peak = peak
+		setBandwidth(p.limiter, p.cfg.ConnBandwidthBytes(t))
+	}
+	return p.limiter
+}
+
+// sessionThrottle returns the wait func of a session, it applies the shared, the traffic spike and the per connection bandwidth
+func (app *App) sessionThrottle(cfg *global.Config, uid string) func(ctx context.Context, n int) error {
+	conn := newPeriodLimiter(cfg, time.Now)
+	return func(ctx context.Context, n int) error {
+		if err := waitLimiter(ctx, app.bandwidth, n); err != nil {
+			return err
+		}
+		if cfg.SpikeDetectionEnabled {
+			if l := app.spikeLimiter(uid); l != nil {
+				if err := waitLimiter(ctx, l, n); err != nil {
+					return err
+				}
+			}
+		}
+		return waitLimiter(ctx, conn.current(), n)
+	}
+}
+
+// throttledWriter waits for bandwidth before every write
+type throttledWriter struct {
+	ctx  context.Context
+	wait func(ctx context.Context, n int) error
+	w    io.Writer
+}
+
+func (t throttledWriter) Write(p []byte) (int, error) {
+	if err := t.wait(t.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return t.w.Write(p)
+}
@@ -0,0 +1,75 @@
+package node
+
+import (
+	"math/bits"
+	"time"
+)
+
+const (
+	bdpMinBuffer   = 4 << 10
+	bdpMaxBuffer   = 4 << 20
+	bdpSampleEvery = time.Millisecond * 100
+	bdpMinRTT      = time.Millisecond //a write that never blocks says little about the path
+	bdpMaxSamples  = 10               //delivery rate samples of the max filter, one second
+)
+
+// bdpEstimator guesses the bandwidth delay product of the client leg from the websocket writes,
+// bandwidth is the max of the recent delivery rates like BBR does, the round trip is inferred
+// from how long the writes block once the socket buffer is full.
+type bdpEstimator struct {
+	rates     [bdpMaxSamples]float64 //bytes per second
+	rateN     int
+	rtt       time.Duration //smoothed write duration
+	sampleAt  time.Time
+	sampleLen int64
+}
+
+func newBDPEstimator() *bdpEstimator {
+	return &bdpEstimator{sampleAt: time.Now(), rtt: bdpMinRTT}
+}
+
+// observe records a write of n bytes that took d, it reports whether a new delivery rate sample was taken
+func (e *bdpEstimator) observe(n int, d time.Duration) bool {
+	e.rtt += (max(d, bdpMinRTT) - e.rtt) / 8
+	e.sampleLen += int64(n)
+	if elapsed := time.Since(e.sampleAt); elapsed >= bdpSampleEvery {
+		e.rates[e.rateN%bdpMaxSamples] = float64(e.sampleLen) / elapsed.Seconds()
+		e.rateN++
+		e.sampleAt, e.sampleLen = time.Now(), 0
+		return true
+	}
+	return false
+}
+
+// bandwidth is the max filtered delivery rate in bytes per second
+func (e *bdpEstimator) bandwidth() float64 {
+	var bw float64
+	for _, r := range e.rates[:min(e.rateN, bdpMaxSamples)] {
+		bw = max(bw, r)
+	}
+	return bw
+}
+
+// bufferSize is the bandwidth delay product rounded up to a power of two within [4KB, 4MB],
+// so the buffer pools only see a handful of sizes
+func (e *bdpEstimator) bufferSize(current int) int {
+	if e.rateN == 0 {
+		return current
+	}
+	bdp := int(e.bandwidth() * e.rtt.Seconds())
+	bdp = min(max(bdp, bdpMinBuffer), bdpMaxBuffer)
+	return 1 << bits.Len(uint(bdp-1))
+}
+
+func (e *bdpEstimator) bandwidthMbps() float64 {
+	return e.bandwidth() * 8 / 1e6
+}
+
+func (e *connEntry) setEstimatedBandwidth(mbps float64) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.estimatedMbps = mbps
+	e.mu.Unlock()
+}
@@ -0,0 +1,56 @@
+package node
+
+import "time"
+
+// userUsage is the traffic of a user in the current calendar month, it starts over with the process
+type userUsage struct {
+	month string //2006-01 in utc
+	bytes int64
+}
+
+// billTraffic splits byteN into the part still within FreeBytesPerUser for this month and the billed rest
+func (app *App) billTraffic(uid string, byteN int64, freeBytes int64) {
+	month := time.Now().UTC().Format("2006-01")
+	app.billingMu.Lock()
+	defer app.billingMu.Unlock()
+	if app.usage == nil {
+		app.usage = make(map[string]*userUsage)
+		app.freeBytes = make(map[string]int64)
+		app.billedBytes = make(map[string]int64)
+	}
+	u, ok := app.usage[uid]
+	if !ok || u.month != month {
+		u = &userUsage{month: month}
+		app.usage[uid] = u
+	}
+	free := min(max(freeBytes-u.bytes, 0), byteN)
+	u.bytes += byteN
+	if free > 0 {
+		app.freeBytes[uid] += free
+	}
+	if byteN > free {
+		app.billedBytes[uid] += byteN - free
+	}
+}
+
+// drainBilling returns the free and billed KB since the last call, the bytes short of a KB are kept for the next one
+func (app *App) drainBilling() (free, billed map[string]int64) {
+	app.billingMu.Lock()
+	defer app.billingMu.Unlock()
+	return drainKB(app.freeBytes), drainKB(app.billedBytes)
+}
+
+func drainKB(m map[string]int64) map[string]int64 {
+	kb := make(map[string]int64)
+	for uid, n := range m {
+		if n >= 1024 {
+			kb[uid] = n / 1024
+		}
+		if n%1024 == 0 {
+			delete(m, uid)
+		} else {
+			m[uid] = n % 1024
+		}
+	}
+	return kb
+}
@@ -0,0 +1,27 @@
+package node
+
+import (
+	"sync"
+)
+
+// getBuffer returns a buffer of size bytes from the pool of that size,
+// recycling the copy buffers keeps connection churn from stressing the gc.
+func (app *App) getBuffer(size int) *[]byte {
+	v, ok := app.bufferPools.Load(size)
+	if !ok {
+		v, _ = app.bufferPools.LoadOrStore(size, &sync.Pool{
+			New: func() any {
+				buf := make([]byte, size)
+				return &buf
+			},
+		})
+	}
+	return v.(*sync.Pool).Get().(*[]byte)
+}
+
+// putBuffer recycles a buffer of getBuffer, it must not be used afterwards
+func (app *App) putBuffer(buf *[]byte) {
+	if v, ok := app.bufferPools.Load(len(*buf)); ok {
+		v.(*sync.Pool).Put(buf)
+	}
+}
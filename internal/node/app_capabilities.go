@@ -0,0 +1,67 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/unchainese/unchain/internal/schema"
+)
+
+const capabilitiesAddonKey = "server-capabilities"
+
+// ServerCapabilities tells a client which optional VLESS features the node has enabled,
+// it is sent in the response header addons when the websocket url carries ?inject-config=1
+type ServerCapabilities struct {
+	Version     string   `json:"version"`
+	Flows       []string `json:"flows"`       //flows besides the classic stream
+	Compression []string `json:"compression"` //eg. zstd, see VLESSCompression
+	FlowControl bool     `json:"flow_control"`
+	Smux        bool     `json:"smux"`
+	PaddingSize int      `json:"padding_size"` //ObfuscationBlockSize
+}
+
+func withInjectConfig(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKeyInjectConfig, true)
+}
+
+func injectConfigFrom(ctx context.Context) bool {
+	inject, _ := ctx.Value(ctxKeyInjectConfig).(bool)
+	return inject
+}
+
+func (app *App) capabilities() ServerCapabilities {
+	cfg := app.config()
+	c := ServerCapabilities{
+		Version:     cfg.GitHash,
+		Flows:       []string{},
+		Compression: []string{},
+		FlowControl: cfg.FlowControlWindowBytes > 0,
+		Smux:        true,
+		PaddingSize: cfg.ObfuscationBlockSize,
+	}
+	if cfg.VLESSCompression {
+		c.Compression = append(c.Compression, zstdAddonKey)
+	}
+	return c
+}
+
+// responseHeader is the VLESS response header with meta as addons, plus the server capabilities when the client asked for them.
+// The addons length is a single byte, capabilities that do not fit are left out.
+func (app *App) responseHeader(ctx context.Context, version byte, meta map[string]string) []byte {
+	if injectConfigFrom(ctx) {
+		caps, _ := json.Marshal(app.capabilities())
+		withCaps := map[string]string{capabilitiesAddonKey: string(caps)}
+		for k, v := range meta {
+			withCaps[k] = v
+		}
+		if addons := (schema.VlessAddons{Meta: withCaps}).Marshal(); len(addons) <= 0xff {
+			return append([]byte{version, byte(len(addons))}, addons...)
+		}
+		app.connLogger(ctx).Warn("server capabilities too long for the vless addons", "bytes", len(caps))
+	}
+	if len(meta) == 0 {
+		return []byte{version, 0x00}
+	}
+	addons := schema.VlessAddons{Meta: meta}.Marshal()
+	return append([]byte{version, byte(len(addons))}, addons...)
+}
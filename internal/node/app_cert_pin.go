@@ -0,0 +1,44 @@
+package node
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+var errCertPinMismatch = errors.New("certificate does not match the pins of the host")
+
+// normalizePin lower cases a hex sha-256 pin and drops the colons of the openssl notation
+func normalizePin(pin string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(pin), ":", ""))
+}
+
+// verifyCertPins returns the tls.Config.VerifyPeerCertificate of an egress connection to serverName,
+// nil when CertPins has no entry for it. It runs after the chain verification and only adds the pin check.
+func (app *App) verifyCertPins(serverName string) func([][]byte, [][]*x509.Certificate) error {
+	var pins []string
+	for host, hostPins := range app.config().CertPins {
+		if strings.EqualFold(host, serverName) {
+			pins = hostPins
+		}
+	}
+	if len(pins) == 0 {
+		return nil
+	}
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errCertPinMismatch
+		}
+		sum := sha256.Sum256(rawCerts[0])
+		got := hex.EncodeToString(sum[:])
+		for _, pin := range pins {
+			if normalizePin(pin) == got {
+				return nil
+			}
+		}
+		app.logger.Error("SECURITY: egress certificate pin mismatch, possible mitm", "host", serverName, "sha256", got)
+		return errCertPinMismatch
+	}
+}
@@ -0,0 +1,86 @@
+package node
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	trafficInteractive = "interactive"
+	trafficBulk        = "bulk"
+	trafficStreaming   = "streaming"
+
+	classifyAfter = time.Second * 2
+)
+
+// trafficClassifier counts the reads of both directions during the first seconds of a session
+type trafficClassifier struct {
+	mu    sync.Mutex
+	reads int
+	bytes int64
+	timer *time.Timer
+}
+
+// newTrafficClassifier calls done with the class of the session once classifyAfter has passed
+func newTrafficClassifier(done func(class string, bytesPerSecond int64)) *trafficClassifier {
+	c := &trafficClassifier{}
+	c.timer = time.AfterFunc(classifyAfter, func() {
+		c.mu.Lock()
+		reads, bytes := c.reads, c.bytes
+		c.mu.Unlock()
+		done(classifyTraffic(reads, bytes), bytes/int64(classifyAfter/time.Second))
+	})
+	return c
+}
+
+func (c *trafficClassifier) observe(n int) {
+	if c == nil || n <= 0 {
+		return
+	}
+	c.mu.Lock()
+	c.reads++
+	c.bytes += int64(n)
+	c.mu.Unlock()
+}
+
+func (c *trafficClassifier) stop() {
+	if c != nil {
+		c.timer.Stop()
+	}
+}
+
+// classifyTraffic tells keystrokes (few small reads) from downloads (megabytes) from everything in between,
+// which is mostly media sent in chunks at a steady rate
+func classifyTraffic(reads int, bytes int64) string {
+	if reads == 0 || bytes/int64(reads) < 256 && bytes < 16<<10 {
+		return trafficInteractive
+	}
+	if bytes >= 2<<20 {
+		return trafficBulk
+	}
+	return trafficStreaming
+}
+
+// tuneSocket applies the socket options of class to the tcp connection under conn
+func tuneSocket(conn net.Conn, class string) {
+	switch class {
+	case trafficInteractive:
+		setNoDelay(conn)
+	case trafficBulk:
+		if tcpConn, ok := unwrapTCP(conn); ok {
+			tcpConn.SetWriteBuffer(4 << 20)
+		}
+	case trafficStreaming:
+		// paced at the rate of the congestion window, the 2 second sample is too short to cap the stream with
+		enablePacing(conn)
+	}
+}
+
+func unwrapTCP(conn net.Conn) (*net.TCPConn, bool) {
+	if u, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = u.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	return tcpConn, ok
+}
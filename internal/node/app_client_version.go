@@ -0,0 +1,37 @@
+package node
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// maxClientVersionLen bounds the stats keys a client can create
+const maxClientVersionLen = 64
+
+// clientVersion returns the X-Client-Version header of r, eg. "Xray/1.8.24", "unknown" when it is missing
+func clientVersion(r *http.Request) string {
+	v := strings.TrimSpace(r.Header.Get("X-Client-Version"))
+	if v == "" {
+		return "unknown"
+	}
+	if len(v) > maxClientVersionLen {
+		v = v[:maxClientVersionLen]
+	}
+	return v
+}
+
+// clientFamily is the lower case client name of a version, eg. "xray" for "Xray/1.8.24"
+func clientFamily(version string) string {
+	name, _, _ := strings.Cut(version, "/")
+	return strings.ToLower(name)
+}
+
+func withClientVersion(ctx context.Context, version string) context.Context {
+	return context.WithValue(ctx, ctxKeyClientVersion, version)
+}
+
+func clientVersionFrom(ctx context.Context) string {
+	v, _ := ctx.Value(ctxKeyClientVersion).(string)
+	return v
+}
@@ -0,0 +1,67 @@
+package node
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"time"
+)
+
+// coalescingWriter merges small writes into fewer TCP segments.
+// Buffered bytes are flushed when the buffer is full or flushInterval after the first pending write,
+// so nothing is held back while the websocket reader blocks on the next message.
+type coalescingWriter struct {
+	mu       sync.Mutex
+	bw       *bufio.Writer
+	interval time.Duration
+	timer    *time.Timer
+	err      error
+}
+
+func newCoalescingWriter(w io.Writer, size int, flushInterval time.Duration) *coalescingWriter {
+	return &coalescingWriter{
+		bw:       bufio.NewWriterSize(w, size),
+		interval: flushInterval,
+	}
+}
+
+func (c *coalescingWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.bw.Write(p)
+	if err != nil {
+		c.err = err
+		return n, err
+	}
+	if c.bw.Buffered() > 0 && c.timer == nil {
+		c.timer = time.AfterFunc(c.interval, c.flushPending)
+	}
+	return n, nil
+}
+
+func (c *coalescingWriter) flushPending() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timer = nil
+	if c.err == nil {
+		c.err = c.bw.Flush()
+	}
+}
+
+// Flush writes out all buffered bytes and cancels the pending timer
+func (c *coalescingWriter) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if c.err != nil {
+		return c.err
+	}
+	c.err = c.bw.Flush()
+	return c.err
+}
@@ -0,0 +1,68 @@
+package node
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/unchainese/unchain/internal/global"
+	"github.com/unchainese/unchain/internal/schema"
+)
+
+const (
+	zstdAddonKey      = "zstd"
+	zstdMaxMessageLen = 8 << 20 //decompressed size limit of one ws message
+)
+
+// zstdCodec compresses every ws message of a session as one zstd frame,
+// both directions of all sessions share it as EncodeAll and DecodeAll are safe for concurrent use
+type zstdCodec struct {
+	level   int
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+// compression returns the codec of a session, nil when the client did not ask for it or VLESSCompression is off.
+// Only the ws messages after the request are compressed, the early data stays plain.
+func (app *App) compression(cfg *global.Config, sv *schema.ProtoVLESS) *zstdCodec {
+	if !cfg.VLESSCompression || sv.Addon(zstdAddonKey) != "1" {
+		return nil
+	}
+	level := cfg.VLESSCompressionLevel
+	if level <= 0 {
+		level = 3
+	}
+	app.zstdMu.Lock()
+	defer app.zstdMu.Unlock()
+	if app.zstd != nil && app.zstd.level == level {
+		return app.zstd
+	}
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	if err != nil {
+		app.logger.Error("Error creating zstd encoder:", "err", err)
+		return nil
+	}
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(zstdMaxMessageLen))
+	if err != nil {
+		app.logger.Error("Error creating zstd decoder:", "err", err)
+		return nil
+	}
+	app.zstd = &zstdCodec{level: level, encoder: encoder, decoder: decoder}
+	return app.zstd
+}
+
+func (app *App) compress(z *zstdCodec, data []byte) []byte {
+	out := z.encoder.EncodeAll(data, nil)
+	app.uncompressedBytes.Add(int64(len(data)))
+	app.compressedBytes.Add(int64(len(out)))
+	return out
+}
+
+func (app *App) decompress(z *zstdCodec, data []byte) ([]byte, error) {
+	out, err := z.decoder.DecodeAll(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing message: %w", err)
+	}
+	app.compressedBytes.Add(int64(len(data)))
+	app.uncompressedBytes.Add(int64(len(out)))
+	return out, nil
+}
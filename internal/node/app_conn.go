@@ -0,0 +1,299 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type ctxKey int
+
+const (
+	ctxKeyConnID ctxKey = iota
+	ctxKeyProtocol
+	ctxKeyTraceID
+	ctxKeyClientVersion
+	ctxKeyTag
+	ctxKeyRemoteIP
+	ctxKeySessionToken
+	ctxKeyCountry
+	ctxKeyInjectConfig
+)
+
+func withConnID(ctx context.Context, connID string) context.Context {
+	return context.WithValue(ctx, ctxKeyConnID, connID)
+}
+
+func connIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKeyConnID).(string)
+	return id
+}
+
+// withTraceID carries the trace id a client sent in the VLESS addons
+func withTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, ctxKeyTraceID, traceID)
+}
+
+func traceIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKeyTraceID).(string)
+	return id
+}
+
+// connLogger is the app logger with the connection attributes ctx carries
+func (app *App) connLogger(ctx context.Context) *slog.Logger {
+	logger := app.logger
+	if id := connIDFrom(ctx); id != "" {
+		logger = logger.With(slog.String("conn_id", id))
+	}
+	if id := traceIDFrom(ctx); id != "" {
+		logger = logger.With(slog.String("trace_id", id))
+	}
+	if tag := tagFrom(ctx); tag != "" {
+		logger = logger.With(slog.String("tag", tag))
+	}
+	if token := sessionTokenFrom(ctx); token != "" {
+		logger = logger.With(slog.String("session_token", token))
+	}
+	if p := protocolFrom(ctx); p != "" {
+		logger = logger.With(slog.String("protocol", p))
+	}
+	return logger
+}
+
+var errConnNotFound = errors.New("connection not found")
+
+// reasons a connection was terminated, logged when it closes
+const (
+	closeClientDisconnect = "client_disconnect"
+	closeServerShutdown   = "server_shutdown"
+	closeIdleTimeout      = "idle_timeout"
+	closeQuotaExceeded    = "quota_exceeded"
+	closeDialFailed       = "dial_failed"
+	closeUpstreamClosed   = "upstream_closed"
+	closeSessionKicked    = "session_kicked"
+	closeMaxDuration      = "max_session_duration_exceeded"
+	closeWatchdogTimeout  = "watchdog_timeout"
+	closeAdminClosed      = "admin_closed"
+	closeMigrated         = "migrated"
+)
+
+// connEntry is an active connection in app.connRegistry, counters are updated by the copy loops
+type connEntry struct {
+	connID    string
+	remoteIP  string
+	target    string
+	startedAt time.Time
+	bytesUp   atomic.Int64 //client -> destination
+	bytesDown atomic.Int64 //destination -> client
+	close     func() error
+	reason    atomic.Pointer[string] //the first close reason wins
+
+	sessionToken string //see SessionTokenSecret
+
+	mu      sync.Mutex
+	uid     string
+	meta    map[string]string       //operator supplied, see SetConnectionMeta
+	class   string                  //see trafficClassifier
+	message func(text string) error //sends a VLESS message frame, nil until the response header of vlessTCP went out
+
+	upstream string //the UpstreamServers entry the session was dialed to
+
+	estimatedMbps float64 //see bdpEstimator
+
+	window    [bandwidthWindow]int64 //bytes of the last seconds, see rotateBandwidth
+	windowN   int                    //rotations so far
+	lastTotal int64
+}
+
+// bandwidthWindow is how many one second buckets BandwidthMbps averages
+const bandwidthWindow = 5
+
+// rotateBandwidth moves the bytes of the past second into the window, called every second
+func (e *connEntry) rotateBandwidth() {
+	total := e.bytesUp.Load() + e.bytesDown.Load()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.window[e.windowN%bandwidthWindow] = total - e.lastTotal
+	e.windowN++
+	e.lastTotal = total
+}
+
+// bandwidthMbps is the average of the window, callers hold e.mu
+func (e *connEntry) bandwidthMbps() float64 {
+	n := min(e.windowN, bandwidthWindow)
+	if n == 0 {
+		return 0
+	}
+	var sum int64
+	for _, b := range e.window[:n] {
+		sum += b
+	}
+	return float64(sum*8) / float64(n) / 1e6
+}
+
+func (e *connEntry) addUp(n int64) {
+	if e != nil {
+		e.bytesUp.Add(n)
+	}
+}
+
+func (e *connEntry) addDown(n int64) {
+	if e != nil {
+		e.bytesDown.Add(n)
+	}
+}
+
+func (e *connEntry) setCloseReason(reason string) {
+	if e != nil {
+		e.reason.CompareAndSwap(nil, &reason)
+	}
+}
+
+func (e *connEntry) closeReason() string {
+	if r := e.reason.Load(); r != nil {
+		return *r
+	}
+	return closeClientDisconnect
+}
+
+func (e *connEntry) setTrafficClass(class string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.class = class
+	e.mu.Unlock()
+}
+
+func (e *connEntry) setMessageWriter(write func(text string) error) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.message = write
+	e.mu.Unlock()
+}
+
+func (e *connEntry) setUID(uid string) {
+	e.mu.Lock()
+	e.uid = uid
+	e.mu.Unlock()
+}
+
+type ConnectionInfo struct {
+	ConnID        string            `json:"conn_id"`
+	UID           string            `json:"uid"`
+	RemoteIP      string            `json:"remote_ip"`
+	Target        string            `json:"target"`
+	StartTime     time.Time         `json:"start_time"`
+	UptimeSeconds int64             `json:"uptime_seconds"`
+	BytesUp       int64             `json:"bytes_up"`
+	BytesDown     int64             `json:"bytes_down"`
+	BandwidthMbps float64           `json:"bandwidth_mbps"`
+	TrafficClass  string            `json:"traffic_class"`
+	Meta          map[string]string `json:"meta"`
+
+	EstimatedBandwidthMbps float64 `json:"estimated_bandwidth_mbps"`
+	SessionToken           string  `json:"session_token"`
+}
+
+func (e *connEntry) info() ConnectionInfo {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	meta := make(map[string]string, len(e.meta))
+	for k, v := range e.meta {
+		meta[k] = v
+	}
+	return ConnectionInfo{
+		ConnID:        e.connID,
+		UID:           e.uid,
+		RemoteIP:      e.remoteIP,
+		Target:        e.target,
+		StartTime:     e.startedAt,
+		UptimeSeconds: int64(time.Since(e.startedAt).Seconds()),
+		BytesUp:       e.bytesUp.Load(),
+		BytesDown:     e.bytesDown.Load(),
+		BandwidthMbps: e.bandwidthMbps(),
+		TrafficClass:  e.class,
+		Meta:          meta,
+
+		EstimatedBandwidthMbps: e.estimatedMbps,
+		SessionToken:           e.sessionToken,
+	}
+}
+
+// connOpen registers an active connection, its close func terminates it on a forced shutdown
+func (app *App) connOpen(e *connEntry) {
+	e.startedAt = time.Now()
+	e.meta = make(map[string]string)
+	app.connWG.Add(1)
+	app.activeConns.Add(1)
+	app.connRegistry.Store(e.connID, e)
+}
+
+func (app *App) connClose(connID string) {
+	if _, ok := app.connRegistry.LoadAndDelete(connID); ok {
+		app.activeConns.Add(-1)
+		app.connWG.Done()
+	}
+}
+
+// connEntryFrom returns the registered connection of ctx, nil when it is not registered
+func (app *App) connEntryFrom(ctx context.Context) *connEntry {
+	v, ok := app.connRegistry.Load(connIDFrom(ctx))
+	if !ok {
+		return nil
+	}
+	return v.(*connEntry)
+}
+
+// closeConnections terminates all active connections and returns how many there were
+func (app *App) closeConnections() int {
+	n := 0
+	app.connRegistry.Range(func(_, value any) bool {
+		n++
+		e := value.(*connEntry)
+		e.setCloseReason(closeServerShutdown)
+		e.close()
+		return true
+	})
+	return n
+}
+
+// SetConnectionMeta tags an active connection, eg. "review" => "flagged"
+func (app *App) SetConnectionMeta(connID string, key, value string) error {
+	v, ok := app.connRegistry.Load(connID)
+	if !ok {
+		return errConnNotFound
+	}
+	e := v.(*connEntry)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.meta[key] = value
+	return nil
+}
+
+// loopBandwidth rotates the bandwidth window of every active connection with one shared ticker
+func (app *App) loopBandwidth() {
+	tk := time.NewTicker(time.Second)
+	defer tk.Stop()
+	for range tk.C {
+		app.connRegistry.Range(func(_, value any) bool {
+			value.(*connEntry).rotateBandwidth()
+			return true
+		})
+	}
+}
+
+// Connections lists the active connections with their traffic and metadata
+func (app *App) Connections() []ConnectionInfo {
+	list := make([]ConnectionInfo, 0)
+	app.connRegistry.Range(func(_, value any) bool {
+		list = append(list, value.(*connEntry).info())
+		return true
+	})
+	return list
+}
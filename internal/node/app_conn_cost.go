@@ -0,0 +1,40 @@
+package node
+
+import "time"
+
+// connectionCost weighs a closed connection for capacity planning, a target not seen since the last stat
+// counts as diversity: score = KB * 0.5 + minutes * 2 + new target * 10
+func connectionCost(bytes int64, duration time.Duration, newTarget bool) float64 {
+	score := float64(bytes)/1024*0.5 + duration.Minutes()*2
+	if newTarget {
+		score += 10
+	}
+	return score
+}
+
+// scoreConnection computes the cost of a closing connection and adds it to the stats of the next push
+func (app *App) scoreConnection(e *connEntry) float64 {
+	app.costMu.Lock()
+	defer app.costMu.Unlock()
+	if app.costTargets == nil {
+		app.costTargets = make(map[string]struct{})
+	}
+	_, seen := app.costTargets[e.target]
+	app.costTargets[e.target] = struct{}{}
+	score := connectionCost(e.bytesUp.Load()+e.bytesDown.Load(), time.Since(e.startedAt), !seen)
+	app.costSum += score
+	app.costN++
+	return score
+}
+
+// drainConnectionCost returns the total and the average cost since the last call
+func (app *App) drainConnectionCost() (total, avg float64) {
+	app.costMu.Lock()
+	defer app.costMu.Unlock()
+	total = app.costSum
+	if app.costN > 0 {
+		avg = total / float64(app.costN)
+	}
+	app.costSum, app.costN, app.costTargets = 0, 0, nil
+	return total, avg
+}
@@ -0,0 +1,27 @@
+package node
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// counterInc adds one to the counter of key in a sync.Map of *atomic.Int64
+func counterInc(m *sync.Map, key string) {
+	v, ok := m.Load(key)
+	if !ok {
+		v, _ = m.LoadOrStore(key, new(atomic.Int64))
+	}
+	v.(*atomic.Int64).Add(1)
+}
+
+// counterDrain returns the counters of m and resets them, like the traffic counters of stat
+func counterDrain(m *sync.Map) map[string]int64 {
+	data := make(map[string]int64)
+	m.Range(func(key, value any) bool {
+		if n := value.(*atomic.Int64).Swap(0); n > 0 {
+			data[key.(string)] = n
+		}
+		return true
+	})
+	return data
+}
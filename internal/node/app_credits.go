@@ -0,0 +1,98 @@
+package node
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const creditRefillEvery = time.Minute
+
+// ConnectionCredits is the connection budget of a user, a client stuck in a reconnect loop spends it
+// and is refused until credits refill
+type ConnectionCredits struct {
+	credits    atomic.Int64
+	lastRefill atomic.Int64 //unix nano
+}
+
+func (app *App) connectionCredits(uid string, limit int64) *ConnectionCredits {
+	if v, ok := app.credits.Load(uid); ok {
+		return v.(*ConnectionCredits)
+	}
+	c := &ConnectionCredits{}
+	c.credits.Store(limit)
+	c.lastRefill.Store(time.Now().UnixNano())
+	v, _ := app.credits.LoadOrStore(uid, c)
+	return v.(*ConnectionCredits)
+}
+
+// refill adds a credit for every full minute since the last refill, up to limit
+func (c *ConnectionCredits) refill(limit int64) {
+	last := c.lastRefill.Load()
+	n := (time.Now().UnixNano() - last) / int64(creditRefillEvery)
+	if n <= 0 || !c.lastRefill.CompareAndSwap(last, last+n*int64(creditRefillEvery)) {
+		return
+	}
+	for {
+		credits := c.credits.Load()
+		if c.credits.CompareAndSwap(credits, min(credits+n, limit)) {
+			return
+		}
+	}
+}
+
+// spend takes a credit, it reports false when none was left
+func (c *ConnectionCredits) spend() bool {
+	for {
+		credits := c.credits.Load()
+		if credits <= 0 {
+			return false
+		}
+		if c.credits.CompareAndSwap(credits, credits-1) {
+			return true
+		}
+	}
+}
+
+// spendConnectionCredit charges a new connection of uid to its MaxConnectionCredits budget
+func (app *App) spendConnectionCredit(uid string) {
+	limit := int64(app.config().MaxConnectionCredits)
+	if limit <= 0 {
+		return
+	}
+	c := app.connectionCredits(uid, limit)
+	c.refill(limit)
+	c.spend()
+}
+
+// creditsExhausted reports whether uid has spent its connection budget, a user without a bucket has full credit.
+// Only call it for allowed users, spendConnectionCredit creates the bucket.
+func (app *App) creditsExhausted(uid string) bool {
+	limit := int64(app.config().MaxConnectionCredits)
+	if limit <= 0 {
+		return false
+	}
+	v, ok := app.credits.Load(uid)
+	if !ok {
+		return false
+	}
+	c := v.(*ConnectionCredits)
+	c.refill(limit)
+	return c.credits.Load() <= 0
+}
+
+// loopPruneCredits forgets the buckets that refilled completely, a new one starts full anyway
+func (app *App) loopPruneCredits() {
+	tk := time.NewTicker(creditRefillEvery)
+	defer tk.Stop()
+	for range tk.C {
+		limit := int64(app.config().MaxConnectionCredits)
+		app.credits.Range(func(key, value any) bool {
+			c := value.(*ConnectionCredits)
+			c.refill(limit)
+			if limit <= 0 || c.credits.Load() >= limit {
+				app.credits.CompareAndDelete(key, value)
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package node
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultDecoyBody = `<!DOCTYPE html>
+<html>
+<head>
+<title>Welcome to nginx!</title>
+</head>
+<body>
+<h1>Welcome to nginx!</h1>
+<p>If you see this page, the nginx web server is successfully installed and
+working. Further configuration is required.</p>
+</body>
+</html>
+`
+
+func loadDecoyBody(file string) ([]byte, error) {
+	if file == "" {
+		return []byte(defaultDecoyBody), nil
+	}
+	body, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load decoy response file:%s %w", file, err)
+	}
+	return body, nil
+}
+
+// probeFacade hides the node behind the decoy website when ProbeResistance is set,
+// websocket upgrades, xhttp posts, long polling, subscriptions and the admin api still reach their handlers.
+func (app *App) probeFacade(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config().ProbeResistance || websocket.IsWebSocketUpgrade(r) ||
+			strings.HasPrefix(r.URL.Path, "/sub/") || strings.HasPrefix(r.URL.Path, "/admin/") ||
+			(r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/xh/")) ||
+			(app.config().LongPollingFallback && isLongPoll(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		app.Decoy(w, r)
+	})
+}
+
+func (app *App) Decoy(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Server", "nginx")
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Last-Modified", app.startedAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+	w.Write(app.decoyBody)
+}
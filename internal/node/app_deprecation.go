@@ -0,0 +1,48 @@
+package node
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+type deprecation struct {
+	once    sync.Once
+	mu      sync.Mutex
+	lastHit time.Time
+}
+
+// deprecated warns once per process that endpoint is still in use and
+// answers 410 Gone once the configured DeprecationDeadline has passed.
+func (app *App) deprecated(endpoint, replacement string, next http.HandlerFunc) http.HandlerFunc {
+	v, _ := app.deprecations.LoadOrStore(endpoint, &deprecation{})
+	d := v.(*deprecation)
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.once.Do(func() {
+			app.logger.Warn("deprecated endpoint " + endpoint + " used; migrate to " + replacement)
+		})
+		d.mu.Lock()
+		d.lastHit = time.Now()
+		d.mu.Unlock()
+		if deadline, ok := app.config().DeprecationDeadlineTime(); ok && time.Now().After(deadline) {
+			http.Error(w, "Gone, use "+replacement, http.StatusGone)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// deprecationWarnings returns the last hit time of every deprecated endpoint in use
+func (app *App) deprecationWarnings() map[string]time.Time {
+	hits := make(map[string]time.Time)
+	app.deprecations.Range(func(key, value any) bool {
+		d := value.(*deprecation)
+		d.mu.Lock()
+		if !d.lastHit.IsZero() {
+			hits[key.(string)] = d.lastHit
+		}
+		d.mu.Unlock()
+		return true
+	})
+	return hits
+}
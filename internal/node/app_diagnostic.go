@@ -0,0 +1,56 @@
+package node
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/unchainese/unchain/internal/schema"
+)
+
+func (app *App) isDiagnosticUser(uid string) bool {
+	diag := app.config().DiagnosticUUID
+	return diag != "" && strings.EqualFold(diag, uid)
+}
+
+// vlessDiagnostic echoes the payload of a DiagnosticUUID session back in VLESS framing,
+// the udp length prefixes survive the echo so tcp and udp clients both see their own data
+func (app *App) vlessDiagnostic(ctx context.Context, sv *schema.ProtoVLESS, ws *websocket.Conn) {
+	logger := app.connLogger(ctx).With(sv.LogArgs()...)
+	logger.Info("diagnostic session started", "remote_ip", remoteIPFrom(ctx))
+	cfg := app.config()
+	write := vlessWriter(ws, cfg)
+	echoed := int64(0)
+	defer func() {
+		logger.Info("diagnostic session ended", "echoed_bytes", echoed)
+	}()
+	if err := write(append(app.responseHeader(ctx, sv.Version, nil), sv.DataTcp()...)); err != nil {
+		logger.Error("Error writing to websocket:", "err", err)
+		return
+	}
+	echoed += int64(len(sv.DataTcp()))
+	for {
+		mt, message, err := ws.ReadMessage()
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			return
+		}
+		if err != nil {
+			logger.Error("Error reading message:", "err", err)
+			return
+		}
+		if mt != websocket.BinaryMessage {
+			continue
+		}
+		if cfg.ObfuscationBlockSize > 0 {
+			if message, err = unpadMessage(message); err != nil {
+				logger.Error("Error unpadding message:", "err", err)
+				return
+			}
+		}
+		if err := write(message); err != nil {
+			logger.Error("Error writing to websocket:", "err", err)
+			return
+		}
+		echoed += int64(len(message))
+	}
+}
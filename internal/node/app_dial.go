@@ -0,0 +1,121 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+)
+
+const dnsCacheSweepEvery = time.Second * 30
+
+type dnsCacheEntry struct {
+	addrs      []string
+	err        error
+	expiry     time.Time
+	isNegative bool
+}
+
+// lookupHost resolves destination host names through app.dnsCache,
+// failures are remembered for NegativeDNSTTL so unreachable hosts don't hammer dns.
+func (app *App) lookupHost(ctx context.Context, host string) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, nil
+	}
+	if addrs := app.hosts.lookup(host); len(addrs) > 0 {
+		return addrs, nil
+	}
+	if app.config().DNSPrefetchTopN > 0 {
+		counterInc(&app.hostHits, host)
+	}
+	if v, ok := app.dnsCache.Load(host); ok {
+		entry := v.(*dnsCacheEntry)
+		if time.Now().Before(entry.expiry) {
+			return entry.addrs, entry.err
+		}
+		app.dnsCache.Delete(host)
+	}
+	entry := app.resolveHost(ctx, host)
+	return entry.addrs, entry.err
+}
+
+// resolveHost looks host up and replaces its app.dnsCache entry, an answer is cached for its TTL up to MaxDNSTTL
+func (app *App) resolveHost(ctx context.Context, host string) *dnsCacheEntry {
+	cfg := app.config()
+	addrs, err := app.resolver.LookupHost(ctx, host)
+	ttl := cfg.MaxDNSTTL()
+	if answerTTL, ok := app.dnsTTLs.take(host); ok {
+		ttl = min(ttl, answerTTL)
+	}
+	entry := &dnsCacheEntry{addrs: addrs, expiry: time.Now().Add(ttl)}
+	if err == nil && len(addrs) == 0 {
+		err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	if err != nil {
+		*entry = dnsCacheEntry{err: err, expiry: time.Now().Add(cfg.NegativeDNSTTL()), isNegative: true}
+	}
+	app.dnsCache.Store(host, entry)
+	return entry
+}
+
+// loopSweepDNSCache drops expired entries, hosts that are never asked again would stay forever
+func (app *App) loopSweepDNSCache() {
+	tk := time.NewTicker(dnsCacheSweepEvery)
+	defer tk.Stop()
+	for range tk.C {
+		now := time.Now()
+		app.dnsCache.Range(func(key, value any) bool {
+			if now.After(value.(*dnsCacheEntry).expiry) {
+				app.dnsCache.CompareAndDelete(key, value)
+			}
+			return true
+		})
+		app.dnsTTLs.sweep(now.Add(-dnsCacheSweepEvery))
+	}
+}
+
+// dial connects to the destination, trying every resolved address until one answers
+func (app *App) dial(ctx context.Context, network, host string, port int, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	addrs, err := app.lookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{}
+	// net falls back to plain tcp by itself when mptcp is unavailable,
+	// IPPROTO_MPTCP is a socket() argument so it can not be set in Dialer.Control
+	dialer.SetMultipathTCP(app.config().UseMPTCP)
+	if app.config().EnableTFO {
+		dialer.Control = app.tfoControl
+	}
+	if v6, v4 := splitFamilies(addrs); app.config().HappyEyeballs && network == "tcp" && len(v6) > 0 && len(v4) > 0 {
+		conn, err := raceDial(ctx, func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, net.JoinHostPort(addr, strconv.Itoa(port)))
+		}, v6, v4, happyEyeballsDelay)
+		if err == nil && app.config().DisableNagle {
+			setNoDelay(conn)
+		}
+		return conn, err
+	}
+	var errs []error
+	for _, addr := range addrs {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr, strconv.Itoa(port)))
+		if err == nil {
+			if app.config().DisableNagle {
+				setNoDelay(conn)
+			}
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// setNoDelay disables Nagle's algorithm on tcp connections, tls connections are unwrapped
+func setNoDelay(conn net.Conn) {
+	if tcpConn, ok := unwrapTCP(conn); ok {
+		tcpConn.SetNoDelay(true)
+	}
+}
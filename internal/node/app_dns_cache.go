@@ -0,0 +1,111 @@
+package node
+
+import (
+	"golang.org/x/net/dns/dnsmessage"
+	"strings"
+	"sync"
+	"time"
+)
+
+type dnsQuestionKey struct {
+	qtype dnsmessage.Type
+	qname string
+}
+
+type dnsResponseEntry struct {
+	response []byte
+	expiry   time.Time
+}
+
+// dnsResponseCache keeps raw dns responses of udp sessions until the smallest answer TTL expires
+type dnsResponseCache struct {
+	mu         sync.Mutex
+	entries    map[dnsQuestionKey]dnsResponseEntry
+	maxEntries int
+}
+
+func newDNSResponseCache(maxEntries int) *dnsResponseCache {
+	return &dnsResponseCache{entries: make(map[dnsQuestionKey]dnsResponseEntry), maxEntries: maxEntries}
+}
+
+func dnsQuestion(msg []byte) (id uint16, key dnsQuestionKey, ok bool) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil {
+		return 0, key, false
+	}
+	q, err := p.Question()
+	if err != nil {
+		return 0, key, false
+	}
+	return h.ID, dnsQuestionKey{qtype: q.Type, qname: strings.ToLower(q.Name.String())}, true
+}
+
+// get answers the query from the cache, the cached response gets the id of the query
+func (c *dnsResponseCache) get(query []byte) ([]byte, bool) {
+	id, key, ok := dnsQuestion(query)
+	if !ok {
+		return nil, false
+	}
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok && time.Now().After(entry.expiry) {
+		delete(c.entries, key)
+		ok = false
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	response := append([]byte(nil), entry.response...)
+	response[0], response[1] = byte(id>>8), byte(id)
+	return response, true
+}
+
+func (c *dnsResponseCache) put(response []byte) {
+	var p dnsmessage.Parser
+	h, err := p.Start(response)
+	if err != nil || !h.Response || h.RCode != dnsmessage.RCodeSuccess {
+		return
+	}
+	q, err := p.Question()
+	if err != nil {
+		return
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return
+	}
+	answers, err := p.AllAnswers()
+	if err != nil || len(answers) == 0 {
+		return
+	}
+	ttl := answers[0].Header.TTL
+	for _, a := range answers[1:] {
+		ttl = min(ttl, a.Header.TTL)
+	}
+	if ttl == 0 {
+		return
+	}
+	key := dnsQuestionKey{qtype: q.Type, qname: strings.ToLower(q.Name.String())}
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.maxEntries {
+		for k, e := range c.entries {
+			if now.After(e.expiry) {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < c.maxEntries {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = dnsResponseEntry{
+		response: append([]byte(nil), response...),
+		expiry:   now.Add(time.Duration(ttl) * time.Second),
+	}
+}
@@ -0,0 +1,65 @@
+package node
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	dnsPrefetchEvery  = time.Second * 10
+	dnsPrefetchWindow = time.Second * 15 //entries expiring sooner are re-resolved
+)
+
+// loopDNSPrefetch keeps the dns cache entries of the DNSPrefetchTopN most used hosts fresh,
+// so the first connection after an expiry does not wait for a lookup.
+func (app *App) loopDNSPrefetch() {
+	go func() {
+		for host := range app.prefetchQueue {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			app.resolveHost(ctx, host)
+			cancel()
+		}
+	}()
+	tk := time.NewTicker(dnsPrefetchEvery)
+	defer tk.Stop()
+	for range tk.C {
+		for _, host := range app.topHosts(app.config().DNSPrefetchTopN) {
+			v, ok := app.dnsCache.Load(host)
+			if ok && time.Until(v.(*dnsCacheEntry).expiry) > dnsPrefetchWindow {
+				continue
+			}
+			select {
+			case app.prefetchQueue <- host:
+			default: //the resolver is behind, the next tick tries again
+			}
+		}
+	}
+}
+
+// topHosts returns the n hosts with the most recent lookups, the counts decay by half on every call
+func (app *App) topHosts(n int) []string {
+	type hits struct {
+		host string
+		n    int64
+	}
+	var list []hits
+	app.hostHits.Range(func(key, value any) bool {
+		counter := value.(*atomic.Int64)
+		c := counter.Load()
+		if c == 0 {
+			app.hostHits.Delete(key)
+			return true
+		}
+		counter.Store(c / 2)
+		list = append(list, hits{key.(string), c})
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool { return list[i].n > list[j].n })
+	hosts := make([]string, 0, n)
+	for i := 0; i < len(list) && i < n; i++ {
+		hosts = append(hosts, list[i].host)
+	}
+	return hosts
+}
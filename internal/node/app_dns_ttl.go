@@ -0,0 +1,132 @@
+package node
+
+import (
+	"context"
+	"golang.org/x/net/dns/dnsmessage"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dnsTTLRecorder keeps the smallest answer TTL of the dns responses read by the resolver of newTTLResolver,
+// net.Resolver does not return the TTL with the addresses
+type dnsTTLRecorder struct {
+	mu      sync.Mutex
+	entries map[string]dnsTTL //lower case fqdn of the question
+}
+
+type dnsTTL struct {
+	ttl  time.Duration
+	seen time.Time
+}
+
+func newDNSTTLRecorder() *dnsTTLRecorder {
+	return &dnsTTLRecorder{entries: make(map[string]dnsTTL)}
+}
+
+// observe records the smallest answer TTL of a dns response, the A and AAAA answers of a lookup share the entry
+func (r *dnsTTLRecorder) observe(msg []byte) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil || !h.Response || h.RCode != dnsmessage.RCodeSuccess {
+		return
+	}
+	q, err := p.Question()
+	if err != nil {
+		return
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return
+	}
+	answers, err := p.AllAnswers()
+	if err != nil || len(answers) == 0 {
+		return
+	}
+	ttl := answers[0].Header.TTL
+	for _, a := range answers[1:] {
+		ttl = min(ttl, a.Header.TTL)
+	}
+	name := strings.ToLower(q.Name.String())
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.entries[name]; ok && now.Sub(e.seen) < time.Second*5 && e.ttl < time.Duration(ttl)*time.Second {
+		return
+	}
+	r.entries[name] = dnsTTL{ttl: time.Duration(ttl) * time.Second, seen: now}
+}
+
+// take returns and forgets the TTL recorded for host
+func (r *dnsTTLRecorder) take(host string) (time.Duration, bool) {
+	name := strings.ToLower(strings.TrimSuffix(host, ".")) + "."
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[name]
+	delete(r.entries, name)
+	return e.ttl, ok
+}
+
+// sweep forgets the TTLs no lookup took, eg. of search domain queries
+func (r *dnsTTLRecorder) sweep(before time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, e := range r.entries {
+		if e.seen.Before(before) {
+			delete(r.entries, name)
+		}
+	}
+}
+
+// newTTLResolver is the go resolver with its dns connections wrapped, the answers they read go to ttls
+func newTTLResolver(ttls *dnsTTLRecorder) *net.Resolver {
+	var dialer net.Dialer
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			if udp, ok := conn.(*net.UDPConn); ok {
+				// the resolver frames a net.PacketConn as datagrams, anything else as a tcp stream
+				return &dnsPacketConn{UDPConn: udp, ttls: ttls}, nil
+			}
+			return &dnsStreamConn{Conn: conn, ttls: ttls}, nil
+		},
+	}
+}
+
+type dnsPacketConn struct {
+	*net.UDPConn
+	ttls *dnsTTLRecorder
+}
+
+func (c *dnsPacketConn) Read(p []byte) (int, error) {
+	n, err := c.UDPConn.Read(p)
+	if n > 0 {
+		c.ttls.observe(p[:n])
+	}
+	return n, err
+}
+
+// dnsStreamConn reads dns over tcp, every message has a two byte length prefix
+type dnsStreamConn struct {
+	net.Conn
+	ttls *dnsTTLRecorder
+	buf  []byte
+}
+
+func (c *dnsStreamConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.buf = append(c.buf, p[:n]...)
+	for len(c.buf) >= 2 {
+		size := int(c.buf[0])<<8 | int(c.buf[1])
+		if len(c.buf) < 2+size {
+			break
+		}
+		c.ttls.observe(c.buf[2 : 2+size])
+		c.buf = c.buf[2+size:]
+	}
+	return n, err
+}
@@ -0,0 +1,87 @@
+package node
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+type errorBatchKey struct {
+	target  string
+	errType string
+}
+
+// errorType is the coarse kind of a dial error the batched reports are grouped by
+func errorType(err error) string {
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	switch {
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "reset"
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		return "unreachable"
+	case errors.As(err, &certErr):
+		return "tls"
+	}
+	return "other"
+}
+
+// batchError counts a failed dial of target for the next error report
+func (app *App) batchError(target string, err error) {
+	app.errorBatchMu.Lock()
+	defer app.errorBatchMu.Unlock()
+	if app.errorBatch == nil {
+		app.errorBatch = make(map[errorBatchKey]int)
+	}
+	app.errorBatch[errorBatchKey{target: target, errType: errorType(err)}]++
+}
+
+type errorReport struct {
+	Target    string         `json:"target"`
+	Count     int            `json:"count"`
+	Errors    map[string]int `json:"errors"` //error type -> count
+	Interval  string         `json:"interval"`
+	Timestamp time.Time      `json:"timestamp"`
+}
+
+// loopErrorBatch posts one report per failing target every ErrorBatchInterval instead of one per failure
+func (app *App) loopErrorBatch() {
+	interval := app.config().ErrorBatchInterval()
+	tk := time.NewTicker(interval)
+	defer tk.Stop()
+	for range tk.C {
+		app.errorBatchMu.Lock()
+		batch := app.errorBatch
+		app.errorBatch = nil
+		app.errorBatchMu.Unlock()
+
+		reports := make(map[string]*errorReport)
+		for key, n := range batch {
+			report, ok := reports[key.target]
+			if !ok {
+				report = &errorReport{Target: key.target, Errors: make(map[string]int), Interval: interval.String(), Timestamp: time.Now()}
+				reports[key.target] = report
+			}
+			report.Count += n
+			report.Errors[key.errType] += n
+		}
+		url := app.config().AlertWebhookURL
+		for _, report := range reports {
+			if url == "" {
+				app.logger.Warn("dial errors", "target", report.Target, "count", report.Count, "errors", report.Errors)
+				continue
+			}
+			app.postWebhook(url, report)
+		}
+	}
+}
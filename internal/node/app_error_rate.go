@@ -0,0 +1,134 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	errorRateBucket     = time.Second * 10
+	errorRateBuckets    = 6 //one minute
+	errorRateMinSamples = 5 //fewer dials do not raise an alert
+)
+
+// dialWindow counts the dials of a target in the buckets of the last minute
+type dialWindow struct {
+	mu        sync.Mutex
+	attempts  [errorRateBuckets]int64
+	failures  [errorRateBuckets]int64
+	epochs    [errorRateBuckets]int64 //bucket number the counts belong to
+	lastAlert time.Time
+}
+
+func (w *dialWindow) add(now time.Time, failed bool) {
+	epoch := now.UnixNano() / int64(errorRateBucket)
+	i := epoch % errorRateBuckets
+	if w.epochs[i] != epoch {
+		w.epochs[i], w.attempts[i], w.failures[i] = epoch, 0, 0
+	}
+	w.attempts[i]++
+	if failed {
+		w.failures[i]++
+	}
+}
+
+// rate returns the failure rate and the dial count of the last minute
+func (w *dialWindow) rate(now time.Time) (float64, int64) {
+	epoch := now.UnixNano() / int64(errorRateBucket)
+	var attempts, failures int64
+	for i := range w.epochs {
+		if epoch-w.epochs[i] < errorRateBuckets {
+			attempts += w.attempts[i]
+			failures += w.failures[i]
+		}
+	}
+	if attempts == 0 {
+		return 0, 0
+	}
+	return float64(failures) / float64(attempts), attempts
+}
+
+type errorRateAlert struct {
+	Target      string    `json:"target"`
+	ErrorRate   float64   `json:"error_rate"`
+	SampleCount int64     `json:"sample_count"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// recordDial counts a dial of target for the batched error reports and posts an alert when its failure rate crosses ErrorRateAlertThreshold
+func (app *App) recordDial(target string, err error) {
+	cfg := app.config()
+	if err != nil && cfg.ErrorBatchIntervalSecond > 0 {
+		app.batchError(target, err)
+	}
+	if cfg.ErrorRateAlertThreshold <= 0 {
+		return
+	}
+	v, ok := app.dialWindows.Load(target)
+	if !ok {
+		v, _ = app.dialWindows.LoadOrStore(target, &dialWindow{})
+	}
+	w := v.(*dialWindow)
+	now := time.Now()
+	w.mu.Lock()
+	w.add(now, err != nil)
+	rate, n := w.rate(now)
+	alert := err != nil && n >= errorRateMinSamples && rate > cfg.ErrorRateAlertThreshold && now.Sub(w.lastAlert) >= cfg.AlertCooldown()
+	if alert {
+		w.lastAlert = now
+	}
+	w.mu.Unlock()
+	if alert && cfg.AlertWebhookURL != "" {
+		payload := errorRateAlert{Target: target, ErrorRate: rate, SampleCount: n, Timestamp: now}
+		go func() {
+			if app.postWebhook(cfg.AlertWebhookURL, payload) {
+				app.logger.Warn("target error rate alert sent", "target", target, "error_rate", rate, "samples", n)
+			}
+		}()
+	}
+}
+
+// postWebhook posts payload as json, it reports whether the webhook was reached
+func (app *App) postWebhook(url string, payload any) bool {
+	body, _ := json.Marshal(payload)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		app.logger.Error("Error creating webhook request:", "err", err)
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.pushClient.Do(req)
+	if err != nil {
+		app.logger.Error("Error posting webhook:", "err", err, "url", url)
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+// targetErrorRates are the failure rates of the targets with failed dials in the last minute,
+// targets without dials in the window are forgotten
+func (app *App) targetErrorRates() map[string]float64 {
+	now := time.Now()
+	rates := make(map[string]float64)
+	app.dialWindows.Range(func(key, value any) bool {
+		w := value.(*dialWindow)
+		w.mu.Lock()
+		rate, n := w.rate(now)
+		idle := n == 0 && now.Sub(w.lastAlert) > app.config().AlertCooldown()
+		w.mu.Unlock()
+		if idle {
+			app.dialWindows.Delete(key)
+		} else if rate > 0 {
+			rates[key.(string)] = rate
+		}
+		return true
+	})
+	return rates
+}
@@ -0,0 +1,51 @@
+package node
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/unchainese/unchain/internal/schema"
+	"strconv"
+	"time"
+)
+
+// flowControlStall is how long a write to the destination may block before the window is closed
+const flowControlStall = time.Millisecond * 100
+
+// flowControl grants a client that sent the "flow-control" addon a window of bytes it may send.
+// Updates are addons encoded text messages with the meta "window", other clients never get them.
+type flowControl struct {
+	window   int
+	received int
+	send     func(mt int, data []byte) error
+}
+
+func newFlowControl(sv *schema.ProtoVLESS, window int, send func(mt int, data []byte) error) *flowControl {
+	if window <= 0 || sv.Addon("flow-control") != "1" {
+		return nil
+	}
+	return &flowControl{window: window, send: send}
+}
+
+func (f *flowControl) update(window int) error {
+	frame := schema.VlessAddons{Meta: map[string]string{"window": strconv.Itoa(window)}}
+	return f.send(websocket.TextMessage, frame.Marshal())
+}
+
+// consumed runs write, which copies bytes of the client to the destination, and grants a new window
+// for every full one. When write stalls the client is told to pause with a window of 0.
+func (f *flowControl) consumed(write func() (int64, error)) (int64, error) {
+	if f == nil {
+		return write()
+	}
+	stall := time.AfterFunc(flowControlStall, func() { f.update(0) })
+	n, err := write()
+	stalled := !stall.Stop()
+	if err != nil {
+		return n, err
+	}
+	f.received += int(n)
+	if f.received >= f.window || stalled {
+		f.received = 0
+		return n, f.update(f.window)
+	}
+	return n, nil
+}
@@ -0,0 +1,49 @@
+package node
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/unchainese/unchain/internal/global"
+)
+
+// clientCountry is the ISO country code cloudflare resolved for the client, the node has no geoip database of its own.
+// Unknown (XX) and tor (T1) clients have no country.
+func clientCountry(r *http.Request) string {
+	country := strings.ToUpper(strings.TrimSpace(r.Header.Get("CF-IPCountry")))
+	if country == "XX" || country == "T1" {
+		return ""
+	}
+	return country
+}
+
+func withCountry(ctx context.Context, country string) context.Context {
+	return context.WithValue(ctx, ctxKeyCountry, country)
+}
+
+func countryFrom(ctx context.Context) string {
+	country, _ := ctx.Value(ctxKeyCountry).(string)
+	return country
+}
+
+// regionalUpstreams narrows UpstreamServers to the ones UpstreamRegions tags with country,
+// all servers when none matches or the country is unknown
+func regionalUpstreams(cfg *global.Config, country string) []string {
+	if country == "" || len(cfg.UpstreamRegions) == 0 {
+		return cfg.UpstreamServers
+	}
+	var servers []string
+	for _, server := range cfg.UpstreamServers {
+		for _, code := range strings.Split(cfg.UpstreamRegions[server], ",") {
+			if strings.EqualFold(strings.TrimSpace(code), country) {
+				servers = append(servers, server)
+				break
+			}
+		}
+	}
+	if len(servers) == 0 {
+		return cfg.UpstreamServers
+	}
+	return servers
+}
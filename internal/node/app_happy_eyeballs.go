@@ -0,0 +1,84 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// happyEyeballsDelay is how long ipv6 leads before ipv4 joins the race, RFC 8305 recommends 250ms
+const happyEyeballsDelay = time.Millisecond * 250
+
+type dialFunc func(ctx context.Context, addr string) (net.Conn, error)
+
+// splitFamilies returns the ipv6 and the ipv4 addresses of addrs in their resolved order
+func splitFamilies(addrs []string) (v6, v4 []string) {
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+			v6 = append(v6, addr)
+		} else {
+			v4 = append(v4, addr)
+		}
+	}
+	return v6, v4
+}
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// raceDial tries the primary addresses, the fallback ones start after delay or as soon as the primary ones failed.
+// The first connection wins, the other attempt is canceled and a connection it still made is closed.
+func raceDial(ctx context.Context, dial dialFunc, primary, fallback []string, delay time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialResult, 2) //buffered so the loser never blocks
+	try := func(addrs []string) {
+		var errs []error
+		for _, addr := range addrs {
+			conn, err := dial(ctx, addr)
+			if err == nil {
+				results <- dialResult{conn: conn}
+				return
+			}
+			errs = append(errs, err)
+		}
+		results <- dialResult{err: errors.Join(errs...)}
+	}
+
+	go try(primary)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	started, pending := false, 1
+	startFallback := func() {
+		if !started && len(fallback) > 0 {
+			started = true
+			pending++
+			go try(fallback)
+		}
+	}
+	var errs []error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			startFallback()
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if pending > 0 {
+					go func() {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}()
+				}
+				return r.conn, nil
+			}
+			errs = append(errs, r.err)
+			startFallback()
+		}
+	}
+	return nil, errors.Join(errs...)
+}
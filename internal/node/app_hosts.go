@@ -0,0 +1,72 @@
+package node
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+const systemHostsFile = "/etc/hosts"
+
+// hostsFileResolver answers destination lookups from hosts files before app.resolver,
+// so entries added for local service discovery also hold when the resolver is not the system one.
+type hostsFileResolver struct {
+	mu         sync.RWMutex
+	hostsCache map[string][]string //lower case name -> addresses in file order
+}
+
+// load replaces the cache with the entries of files, a missing file is skipped
+func (h *hostsFileResolver) load(files ...string) error {
+	hosts := make(map[string][]string)
+	var errs []error
+	for _, file := range files {
+		if err := parseHostsFile(file, hosts); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	h.mu.Lock()
+	h.hostsCache = hosts
+	h.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+func (h *hostsFileResolver) lookup(host string) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.hostsCache[strings.ToLower(strings.TrimSuffix(host, "."))]
+}
+
+func parseHostsFile(file string, hosts map[string][]string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			hosts[name] = append(hosts[name], fields[0])
+		}
+	}
+	return scanner.Err()
+}
+
+func (app *App) loadHosts() {
+	files := []string{systemHostsFile}
+	if extra := app.config().ExtraHostsFile; extra != "" {
+		files = append(files, extra)
+	}
+	if err := app.hosts.load(files...); err != nil {
+		app.logger.Error("Error reading hosts file:", "err", err)
+	}
+}
@@ -0,0 +1,244 @@
+package node
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// a chunked body is only rewritten with a Content-Length when it ends within maxDechunkedBody bytes and
+// dechunkWait of silence, streaming uploads and large bodies are passed on chunked
+const (
+	maxDechunkedBody = 64 << 10
+	dechunkWait      = time.Millisecond * 100
+)
+
+var http1Methods = [][]byte{
+	[]byte("GET "), []byte("POST "), []byte("PUT "), []byte("DELETE "), []byte("HEAD "),
+	[]byte("OPTIONS "), []byte("PATCH "), []byte("CONNECT "), []byte("TRACE "),
+}
+
+// isHTTP1Request reports whether buf starts with a http/1.x request line
+func isHTTP1Request(buf []byte) bool {
+	line := buf
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		line = buf[:i]
+	}
+	for _, m := range http1Methods {
+		if bytes.HasPrefix(line, m) {
+			return bytes.Contains(line, []byte(" HTTP/1."))
+		}
+	}
+	return false
+}
+
+// isHTTP1Response reports whether buf starts with a http/1.x status line
+func isHTTP1Response(buf []byte) bool {
+	return bytes.HasPrefix(buf, []byte("HTTP/1.0 ")) || bytes.HasPrefix(buf, []byte("HTTP/1.1 "))
+}
+
+// rewriteHTTP1Request parses every request of a keep-alive stream, lets rewrite modify it and writes it to w.
+// The stream is copied untouched from the first bytes that are not a request, or after an upgrade.
+func rewriteHTTP1Request(r io.Reader, w io.Writer, rewrite func(req *http.Request) error) error {
+	br := bufio.NewReader(r)
+	for {
+		if _, err := br.Peek(1); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		next, _ := br.Peek(br.Buffered())
+		if !isHTTP1Request(next) {
+			break
+		}
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return fmt.Errorf("reading http request: %w", err)
+		}
+		if err := writeHTTP1Request(w, req, rewrite); err != nil {
+			return err
+		}
+		if req.Method == http.MethodConnect || req.Header.Get("Upgrade") != "" {
+			break
+		}
+	}
+	_, err := io.Copy(w, br)
+	return err
+}
+
+func writeHTTP1Request(w io.Writer, req *http.Request, rewrite func(req *http.Request) error) error {
+	defer req.Body.Close()
+	if err := rewrite(req); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s %s %s\r\n", req.Method, req.RequestURI, req.Proto)
+	if req.Host != "" {
+		fmt.Fprintf(bw, "Host: %s\r\n", req.Host)
+	}
+	if len(req.TransferEncoding) > 0 {
+		req.Header.Set("Transfer-Encoding", "chunked")
+		if err := req.Header.Write(bw); err != nil {
+			return err
+		}
+		bw.WriteString("\r\n")
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		// the body reader of net/http has already removed the chunk framing
+		cw := httpChunkedWriter(w)
+		if _, err := io.Copy(cw, req.Body); err != nil {
+			return err
+		}
+		if err := cw.Close(); err != nil {
+			return err
+		}
+	} else {
+		if err := req.Header.Write(bw); err != nil {
+			return err
+		}
+		bw.WriteString("\r\n")
+		// the headers go out before the body, a client may wait for 100 Continue
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, req.Body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dechunkRequest replaces a chunked body with the decoded bytes and a matching Content-Length
+// when the body ends soon enough, see maxDechunkedBody. Other bodies keep streaming chunked.
+func dechunkRequest(req *http.Request) error {
+	if len(req.TransferEncoding) == 0 || req.TransferEncoding[0] != "chunked" {
+		return nil
+	}
+	body := newBodyPump(req.Body)
+	req.Body = body
+	decoded, complete, err := body.collect(maxDechunkedBody, dechunkWait)
+	if err != nil {
+		return fmt.Errorf("decoding chunked body: %w", err)
+	}
+	if !complete {
+		return nil
+	}
+	req.TransferEncoding = nil
+	req.Header.Del("Transfer-Encoding")
+	req.Header.Set("Content-Length", strconv.Itoa(len(decoded)))
+	req.ContentLength = int64(len(decoded))
+	req.Body = io.NopCloser(bytes.NewReader(decoded))
+	return nil
+}
+
+// bodyPump reads a request body in a goroutine, so collect can give up on a body that stalls
+type bodyPump struct {
+	reads   chan bodyRead
+	stop    chan struct{}
+	pending []byte
+	err     error
+	once    sync.Once
+}
+
+type bodyRead struct {
+	p   []byte
+	err error
+}
+
+func newBodyPump(body io.Reader) *bodyPump {
+	b := &bodyPump{reads: make(chan bodyRead), stop: make(chan struct{})}
+	go func() {
+		for {
+			p := make([]byte, 32<<10)
+			n, err := body.Read(p)
+			select {
+			case b.reads <- bodyRead{p[:n], err}:
+			case <-b.stop:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return b
+}
+
+// collect reads the body until it ends, exceeds limit bytes or stays silent for wait.
+// complete reports whether the body ended, an incomplete body is still read through Read.
+func (b *bodyPump) collect(limit int, wait time.Duration) (body []byte, complete bool, err error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for len(b.pending) <= limit {
+		select {
+		case r := <-b.reads:
+			b.pending = append(b.pending, r.p...)
+			if errors.Is(r.err, io.EOF) {
+				return b.pending, true, nil
+			}
+			if r.err != nil {
+				return nil, false, r.err
+			}
+			timer.Reset(wait)
+		case <-timer.C:
+			return nil, false, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func (b *bodyPump) Read(p []byte) (int, error) {
+	for len(b.pending) == 0 {
+		if b.err != nil {
+			return 0, b.err
+		}
+		r := <-b.reads
+		b.pending, b.err = r.p, r.err
+	}
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+// Close ends the goroutine of a body that is not read to its end
+func (b *bodyPump) Close() error {
+	b.once.Do(func() { close(b.stop) })
+	return nil
+}
+
+// chunkedWriter re-frames a decoded body as http/1.1 chunks
+type chunkedWriter struct {
+	w io.Writer
+}
+
+func httpChunkedWriter(w io.Writer) *chunkedWriter {
+	return &chunkedWriter{w: w}
+}
+
+func (c *chunkedWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := fmt.Fprintf(c.w, "%x\r\n", len(p)); err != nil {
+		return 0, err
+	}
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_, err = io.WriteString(c.w, "\r\n")
+	return n, err
+}
+
+func (c *chunkedWriter) Close() error {
+	_, err := io.WriteString(c.w, "0\r\n\r\n")
+	return err
+}
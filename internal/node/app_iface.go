@@ -0,0 +1,45 @@
+package node
+
+import (
+	"net"
+)
+
+type NetworkIface struct {
+	Name  string   `json:"name"`
+	Addrs []string `json:"addrs"`
+}
+
+// networkInterfaces lists the routable addresses of the node, loopback and link-local ones are left out
+func (app *App) networkInterfaces() []NetworkIface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		app.logger.Error("failed to list network interfaces", "err", err)
+		return nil
+	}
+	list := make([]NetworkIface, 0, len(ifaces))
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		item := NetworkIface{Name: iface.Name}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip := ipNet.IP
+			if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+				continue
+			}
+			item.Addrs = append(item.Addrs, ip.String())
+		}
+		if len(item.Addrs) > 0 {
+			list = append(list, item)
+		}
+	}
+	return list
+}
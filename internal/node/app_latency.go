@@ -0,0 +1,64 @@
+package node
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// latencyRingSize is how many recent samples of a target are kept to compute P95Ms
+const latencyRingSize = 128
+
+type LatencyBucket struct {
+	Count   int64 `json:"count"`
+	TotalMs int64 `json:"total_ms"`
+	P95Ms   int64 `json:"p95_ms"`
+}
+
+type latencyRing struct {
+	mu      sync.Mutex
+	samples [latencyRingSize]int64
+	count   int64
+	totalMs int64
+}
+
+func (l *latencyRing) add(ms int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.samples[l.count%latencyRingSize] = ms
+	l.count++
+	l.totalMs += ms
+}
+
+func (l *latencyRing) bucket() *LatencyBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := min(l.count, latencyRingSize)
+	sorted := slices.Clone(l.samples[:n])
+	slices.Sort(sorted)
+	b := &LatencyBucket{Count: l.count, TotalMs: l.totalMs}
+	if n > 0 {
+		b.P95Ms = sorted[(n*95-1)/100]
+	}
+	return b
+}
+
+// recordTTFB adds the time from dialing the target to its first byte, aggregated per target host
+func (app *App) recordTTFB(host string, ttfb time.Duration) {
+	v, ok := app.targetLatency.Load(host)
+	if !ok {
+		v, _ = app.targetLatency.LoadOrStore(host, &latencyRing{})
+	}
+	v.(*latencyRing).add(ttfb.Milliseconds())
+}
+
+// drainTargetLatency computes the buckets of every target and starts over, like the traffic counters
+func (app *App) drainTargetLatency() map[string]*LatencyBucket {
+	data := make(map[string]*LatencyBucket)
+	app.targetLatency.Range(func(key, value any) bool {
+		app.targetLatency.Delete(key)
+		data[key.(string)] = value.(*latencyRing).bucket()
+		return true
+	})
+	return data
+}
@@ -0,0 +1,94 @@
+package node
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	latencyExploreRate   = 0.1  //share of the sessions sent to a random upstream to keep the matrix fresh
+	latencyMatrixTargets = 4096 //target hosts remembered per upstream
+)
+
+// LatencyStats is the smoothed first byte latency of a target host through one upstream
+type LatencyStats struct {
+	Count  int64
+	AvgMs  float64 //exponentially weighted, recent sessions count more
+	SeenAt time.Time
+}
+
+func (s *LatencyStats) add(ms float64) {
+	if s.Count == 0 {
+		s.AvgMs = ms
+	} else {
+		s.AvgMs += (ms - s.AvgMs) / 8
+	}
+	s.Count++
+	s.SeenAt = time.Now()
+}
+
+// recordUpstreamLatency updates the latency matrix with a session through upstream to host
+func (app *App) recordUpstreamLatency(upstream, host string, ttfb time.Duration) {
+	app.latencyMu.Lock()
+	defer app.latencyMu.Unlock()
+	if app.latencyMatrix == nil {
+		app.latencyMatrix = make(map[string]map[string]*LatencyStats)
+	}
+	targets, ok := app.latencyMatrix[upstream]
+	if !ok {
+		targets = make(map[string]*LatencyStats)
+		app.latencyMatrix[upstream] = targets
+	}
+	stats, ok := targets[host]
+	if !ok {
+		if len(targets) >= latencyMatrixTargets {
+			return
+		}
+		stats = &LatencyStats{}
+		targets[host] = stats
+	}
+	stats.add(float64(ttfb.Milliseconds()))
+}
+
+// fastestUpstream picks the server of servers with the lowest latency to host. Servers without samples
+// for host are tried first, and latencyExploreRate of the picks are random so a recovered server gets back.
+func (app *App) fastestUpstream(servers []string, host string) string {
+	if len(servers) == 1 {
+		return servers[0]
+	}
+	if rand.Float64() < latencyExploreRate {
+		return servers[rand.IntN(len(servers))]
+	}
+	app.latencyMu.Lock()
+	defer app.latencyMu.Unlock()
+	var best string
+	bestMs := 0.0
+	for _, server := range servers {
+		stats, ok := app.latencyMatrix[server][host]
+		if !ok {
+			return server
+		}
+		if best == "" || stats.AvgMs < bestMs {
+			best, bestMs = server, stats.AvgMs
+		}
+	}
+	return best
+}
+
+func (e *connEntry) setUpstream(server string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.upstream = server
+	e.mu.Unlock()
+}
+
+func (e *connEntry) upstreamServer() string {
+	if e == nil {
+		return ""
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.upstream
+}
@@ -0,0 +1,142 @@
+package node
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/unchainese/unchain/internal/schema"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// maxLongPollBody bounds the vless request and every upload request of a long polling session
+const maxLongPollBody = 1 << 20
+
+// pollSession is a long polling session, upload requests find its destination conn by its secret.
+// The secret is not the conn id, which shows up in X-Connection-ID, the logs and the admin api.
+type pollSession struct {
+	uid      string
+	remoteIP string
+	connID   string
+	conn     net.Conn
+	throttle func(ctx context.Context, n int) error //shared by the download and the uploads
+	uploaded atomic.Int64                           //billed with the download once the session ends
+}
+
+// isLongPoll reports whether r uses the long polling fallback of WsVLESS
+func isLongPoll(r *http.Request) bool {
+	return r.Header.Get("X-VLESS-Fallback") == "long-poll"
+}
+
+// WsLongPoll serves VLESS without websocket for networks that block upgrades.
+// The first request carries the vless request in its body and streams the download as its response,
+// the secret of the session is returned in X-VLESS-Session. Each further request with that header from the same ip
+// uploads its body.
+func (app *App) WsLongPoll(w http.ResponseWriter, r *http.Request) {
+	if !app.admitRequest(w, r) {
+		return
+	}
+	body := http.MaxBytesReader(w, r.Body, maxLongPollBody)
+	if secret := r.Header.Get("X-VLESS-Session"); secret != "" {
+		app.longPollUpload(w, r, secret, body)
+		return
+	}
+	connID := uuid.NewString()
+	ctx := withRemoteIP(withConnID(r.Context(), connID), realIP(r))
+	logger := app.connLogger(ctx)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	request, err := io.ReadAll(body)
+	if err != nil {
+		logger.Error("Error reading vless request:", "err", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	vData, err := schema.VlessParse(request)
+	if err != nil {
+		logger.Error("Error parsing vless data:", "err", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if uid := r.PathValue("uid"); uid != "" && uid != vData.UUID() {
+		logger.Warn("vless user differs from the long polling path", "uid", vData.UUID())
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if vData.DstProtocol != "tcp" {
+		logger.Error("Error unsupported protocol:", "network", vData.DstProtocol)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	release, err := app.admitUser(vData.UUID(), realIP(r), connID, true, logger)
+	if err != nil {
+		http.Error(w, http.StatusText(admissionStatus(err)), admissionStatus(err))
+		return
+	}
+	defer release()
+	logger = logger.With(vData.LogArgs()...)
+	conn, headerVLESS, err := app.startDstConnection(ctx, vData, time.Millisecond*1000)
+	if err != nil {
+		logger.Error("Error starting session:", "err", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+	defer conn.Close()
+	entry := &connEntry{connID: connID, uid: vData.UUID(), remoteIP: realIP(r), target: vData.HostPort(), close: conn.Close}
+	app.connOpen(entry)
+	defer app.connClose(connID)
+	secret := uuid.NewString()
+	throttle := app.sessionThrottle(app.config(), vData.UUID())
+	session := &pollSession{uid: vData.UUID(), remoteIP: realIP(r), connID: connID, conn: conn, throttle: throttle}
+	app.pollSessions.Store(secret, session)
+	defer app.pollSessions.Delete(secret)
+	logger.Info("Session started long polling")
+
+	trafficN := int64(len(request))
+	entry.addUp(int64(len(vData.DataTcp())))
+	if _, err := conn.Write(vData.DataTcp()); err != nil {
+		logger.Error("Error writing early data to TCP connection:", "err", err)
+		return
+	}
+	w.Header().Set("X-VLESS-Session", secret)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(headerVLESS)
+	flusher.Flush()
+
+	var trafficMeter atomic.Int64
+	app.copyToResponse(throttledWriter{ctx, throttle, w}, flusher, conn, entry, &trafficMeter, logger)
+	app.trafficInc(vData.UUID(), trafficN+trafficMeter.Load()+session.uploaded.Load())
+}
+
+func (app *App) longPollUpload(w http.ResponseWriter, r *http.Request, secret string, body io.Reader) {
+	v, ok := app.pollSessions.Load(secret)
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	session := v.(*pollSession)
+	if uid := r.PathValue("uid"); session.remoteIP != realIP(r) || (uid != "" && uid != session.uid) {
+		// the same answer as an unknown secret
+		app.logger.Warn("long polling upload from another client", "conn_id", session.connID, "remote_ip", realIP(r))
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	n, err := io.Copy(throttledWriter{r.Context(), session.throttle, session.conn}, body)
+	if e, ok := app.connRegistry.Load(session.connID); ok {
+		e.(*connEntry).addUp(n)
+	}
+	session.uploaded.Add(n)
+	if err != nil {
+		app.logger.Error("Error writing to TCP connection:", "err", err, "conn_id", session.connID)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
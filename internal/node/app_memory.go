@@ -0,0 +1,36 @@
+package node
+
+import (
+	"runtime"
+	"time"
+)
+
+// memStatsMaxAge bounds how often runtime.ReadMemStats, which stops the world, runs for admission
+const memStatsMaxAge = time.Millisecond * 500
+
+// memoryPressure reports whether the heap exceeds MemoryPressureThresholdMB, the circuit open and close are logged
+func (app *App) memoryPressure() bool {
+	threshold := app.config().MemoryPressureThresholdMB
+	if threshold <= 0 {
+		return false
+	}
+	app.memMu.Lock()
+	defer app.memMu.Unlock()
+	if time.Since(app.memReadAt) < memStatsMaxAge {
+		return app.memPressure
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	app.memReadAt = time.Now()
+	heapMB := m.HeapAlloc >> 20
+	pressure := heapMB > uint64(threshold)
+	if pressure != app.memPressure {
+		if pressure {
+			app.logger.Warn("memory pressure, refusing new connections", "heap_mb", heapMB, "threshold_mb", threshold)
+		} else {
+			app.logger.Warn("memory pressure over, accepting connections", "heap_mb", heapMB, "threshold_mb", threshold)
+		}
+	}
+	app.memPressure = pressure
+	return pressure
+}
@@ -0,0 +1,264 @@
+package node
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	headerMigrationCookie = "X-Migration-Cookie"
+	// X-Migration-Proof is the hex HMAC-SHA256 of the cookie keyed with the uuid of the user,
+	// a cookie alone does not take a session over
+	headerMigrationProof = "X-Migration-Proof"
+	// X-Migration-Offset of the request counts the bytes of the session the client received,
+	// the one of the upgrade response the bytes of the client the node wrote to the destination
+	headerMigrationOffset = "X-Migration-Offset"
+	migrationReplaySize   = 1 << 20
+	migrationTakeoverWait = time.Second * 5
+)
+
+// migratingSession is a tcp session whose destination outlives the websocket, a client that moved to
+// another network takes it over with the cookie instead of dialing the destination again.
+// Bytes in flight when the old websocket broke are sent again: the node replays what the client missed
+// from the last migrationReplaySize bytes, the client resends what the node did not receive.
+// The replay ring costs migrationReplaySize bytes per session once the destination sent anything.
+type migratingSession struct {
+	cookie   string
+	received atomic.Int64 //bytes of the client written to the destination
+
+	mu       sync.Mutex
+	uid      string
+	remoteIP string
+	conn     net.Conn      //the destination, nil until the first websocket dialed it
+	held     bool          //a websocket serves the session
+	detach   func()        //closes the websocket serving the session, nil until vlessTCP took it
+	parked   chan struct{} //closed once the websocket let go of the session
+	expiry   *time.Timer
+	done     bool
+	offset   int64  //bytes received by the client taking the session over
+	sent     int64  //bytes of the destination sent to the client
+	replay   []byte //ring of the last bytes of sent, the byte n of the session at n % migrationReplaySize
+	kept     int64  //bytes of the replay before sent
+}
+
+func newMigratingSession() *migratingSession {
+	return &migratingSession{cookie: uuid.NewString(), held: true, parked: make(chan struct{})}
+}
+
+// destination is the connection a websocket takes over, nil for a new session
+func (ms *migratingSession) destination() net.Conn {
+	if ms == nil {
+		return nil
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.conn
+}
+
+func (ms *migratingSession) owner() string {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.uid
+}
+
+func (ms *migratingSession) addReceived(n int64) {
+	if ms != nil {
+		ms.received.Add(n)
+	}
+}
+
+// record keeps data sent to the client for a replay after a migration
+func (ms *migratingSession) record(data []byte) {
+	if ms == nil || len(data) == 0 {
+		return
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.replay == nil {
+		ms.replay = make([]byte, migrationReplaySize)
+	}
+	if len(data) > migrationReplaySize {
+		ms.sent += int64(len(data) - migrationReplaySize)
+		data = data[len(data)-migrationReplaySize:]
+	}
+	for p := data; len(p) > 0; {
+		n := copy(ms.replay[ms.sent%migrationReplaySize:], p)
+		p = p[n:]
+		ms.sent += int64(n)
+	}
+	ms.kept = min(ms.kept+int64(len(data)), migrationReplaySize)
+}
+
+// replayFrom copies the bytes of the replay from the byte offset of the session to sent
+func (ms *migratingSession) replayFrom(offset int64) []byte {
+	out := make([]byte, 0, ms.sent-offset)
+	for n := offset; n < ms.sent; {
+		part := ms.replay[n%migrationReplaySize:]
+		part = part[:min(int64(len(part)), ms.sent-n)]
+		out = append(out, part...)
+		n += int64(len(part))
+	}
+	return out
+}
+
+// migrationProof is the X-Migration-Proof of cookie for the user uid
+func migrationProof(cookie, uid string) string {
+	mac := hmac.New(sha256.New, []byte(uid))
+	mac.Write([]byte(cookie))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// claimMigration hands the session of cookie to a new websocket, one still serving it is closed first.
+// offset and proof are the X-Migration-Offset and X-Migration-Proof of the client, the status explains a nil session.
+func (app *App) claimMigration(cookie, offset, proof string) (*migratingSession, int) {
+	v, ok := app.migrations.Load(cookie)
+	if !ok {
+		return nil, http.StatusGone
+	}
+	ms := v.(*migratingSession)
+	received, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return nil, http.StatusBadRequest
+	}
+	// checked before the websocket of the owner is closed
+	if !hmac.Equal([]byte(proof), []byte(migrationProof(cookie, ms.owner()))) {
+		return nil, http.StatusForbidden
+	}
+	ms.mu.Lock()
+	if ms.held {
+		// the old network often looks alive to the node long after the client left it
+		detach, parked := ms.detach, ms.parked
+		ms.mu.Unlock()
+		if detach != nil {
+			detach()
+		}
+		select {
+		case <-parked:
+		case <-time.After(migrationTakeoverWait):
+			return nil, http.StatusConflict
+		}
+		ms.mu.Lock()
+	}
+	defer ms.mu.Unlock()
+	if ms.done {
+		return nil, http.StatusGone
+	}
+	if ms.held {
+		return nil, http.StatusConflict
+	}
+	if received > ms.sent || received < ms.sent-ms.kept {
+		// the client missed more than the replay keeps
+		return nil, http.StatusConflict
+	}
+	ms.expiry.Stop()
+	ms.held = true
+	ms.parked = make(chan struct{})
+	ms.offset = received
+	return ms, 0
+}
+
+// attachMigration gives the session to the websocket of vlessTCP, detach closes that websocket.
+// A session taken over returns the bytes the client missed and the address it came from.
+func (app *App) attachMigration(ms *migratingSession, uid, remoteIP string, conn net.Conn, detach func()) (pending []byte, fromIP string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.detach = detach
+	fromIP, ms.remoteIP = ms.remoteIP, remoteIP
+	if ms.conn == nil {
+		ms.uid, ms.conn = uid, conn
+		app.migrations.Store(ms.cookie, ms)
+		return nil, ""
+	}
+	pending = ms.replayFrom(ms.offset)
+	// the pending bytes are recorded again once they are sent
+	ms.kept -= ms.sent - ms.offset
+	ms.sent = ms.offset
+	return pending, fromIP
+}
+
+// parkMigration keeps the destination of a session whose websocket dropped for MigrationGrace
+func (app *App) parkMigration(ms *migratingSession) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.done || !ms.held {
+		return
+	}
+	ms.conn.SetReadDeadline(time.Time{})
+	ms.held, ms.detach = false, nil
+	close(ms.parked)
+	ms.expiry = time.AfterFunc(app.config().MigrationGrace(), func() {
+		ms.mu.Lock()
+		defer ms.mu.Unlock()
+		if ms.held || ms.done {
+			return
+		}
+		ms.done = true
+		ms.conn.Close()
+		app.migrations.CompareAndDelete(ms.cookie, ms)
+		app.logger.Debug("migratable session expired", "uid", ms.uid, "remote_ip", ms.remoteIP)
+	})
+}
+
+// releaseMigration parks a session the websocket of the handler claimed but never attached
+func (app *App) releaseMigration(ms *migratingSession) {
+	if ms == nil {
+		return
+	}
+	ms.mu.Lock()
+	unused := ms.held && !ms.done && ms.conn != nil && ms.detach == nil
+	ms.mu.Unlock()
+	if unused {
+		app.parkMigration(ms)
+	}
+}
+
+// endMigration forgets a session whose destination is closed
+func (app *App) endMigration(ms *migratingSession) {
+	if ms == nil {
+		return
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.done {
+		return
+	}
+	ms.done = true
+	if ms.held {
+		close(ms.parked)
+	}
+	app.migrations.CompareAndDelete(ms.cookie, ms)
+}
+
+// migrationParkable reports whether the session ended with the websocket of the client,
+// sessions closed on purpose by the node are not kept for a migration
+func migrationParkable(entry *connEntry) bool {
+	switch entry.closeReason() {
+	case closeClientDisconnect, closeWatchdogTimeout, closeMigrated:
+		return true
+	}
+	return false
+}
+
+// replayConn reads the bytes a migrated client missed before those of the destination
+type replayConn struct {
+	net.Conn
+	pending []byte
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
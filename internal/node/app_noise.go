@@ -0,0 +1,48 @@
+package node
+
+import (
+	"crypto/rand"
+	mrand "math/rand/v2"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// noiseMaxPayload is the payload limit of websocket control frames
+const noiseMaxPayload = 125
+
+// startNoise sends a dummy frame of 1-125 random bytes every NoiseInterval ± 50% while the session runs.
+// The frames are websocket pings, which every client discards (answering with a pong) without a change
+// to the VLESS stream, a VLESS level dummy frame would corrupt clients that do not know it.
+func (app *App) startNoise(ws *websocket.Conn) (stop func()) {
+	cfg := app.config()
+	if !cfg.AntiTimingNoise {
+		return func() {}
+	}
+	interval := cfg.NoiseInterval()
+	done := make(chan struct{})
+	go func() {
+		timer := time.NewTimer(jitter(interval))
+		defer timer.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-timer.C:
+			}
+			payload := make([]byte, 1+mrand.IntN(noiseMaxPayload))
+			rand.Read(payload)
+			if err := ws.WriteControl(websocket.PingMessage, payload, time.Now().Add(cfg.WriteTimeout())); err != nil {
+				return
+			}
+			app.noiseBytes.Add(int64(len(payload)))
+			timer.Reset(jitter(interval))
+		}
+	}()
+	return func() { close(done) }
+}
+
+// jitter returns a duration uniformly within d ± 50%
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(mrand.Int64N(int64(d)+1))
+}
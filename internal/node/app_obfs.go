@@ -0,0 +1,61 @@
+package node
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/unchainese/unchain/internal/global"
+)
+
+// obfsMaxPayload is the most a padded message can carry behind its 2 byte length prefix
+const obfsMaxPayload = 0xffff
+
+// padMessage frames payload as [2 byte length][payload][random padding] up to a multiple of block
+func padMessage(payload []byte, block int) []byte {
+	size := 2 + len(payload)
+	if rem := size % block; rem != 0 {
+		size += block - rem
+	}
+	msg := make([]byte, size)
+	binary.BigEndian.PutUint16(msg, uint16(len(payload)))
+	n := copy(msg[2:], payload)
+	rand.Read(msg[2+n:])
+	return msg
+}
+
+// unpadMessage returns the payload of a message framed by padMessage
+func unpadMessage(msg []byte) ([]byte, error) {
+	if len(msg) < 2 {
+		return nil, errors.New("padded message without length")
+	}
+	n := int(binary.BigEndian.Uint16(msg))
+	if 2+n > len(msg) {
+		return nil, errors.New("padded message shorter than its length")
+	}
+	return msg[2 : 2+n], nil
+}
+
+// wsWriter sends a payload to the vless client
+type wsWriter func(data []byte) error
+
+// vlessWriter writes binary messages with the write timeout, padded when ObfuscationBlockSize is set
+func vlessWriter(ws *websocket.Conn, cfg *global.Config) wsWriter {
+	timeout := cfg.WriteTimeout()
+	block := cfg.ObfuscationBlockSize
+	if block <= 0 {
+		return func(data []byte) error {
+			return writeWs(ws, data, timeout)
+		}
+	}
+	return func(data []byte) error {
+		for len(data) > 0 {
+			chunk := data[:min(len(data), obfsMaxPayload)]
+			data = data[len(chunk):]
+			if err := writeWs(ws, padMessage(chunk, block), timeout); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,102 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/unchainese/unchain/internal/global"
+	"github.com/unchainese/unchain/internal/schema"
+)
+
+// dialOnion reaches host:port through the OnionRelays chain, every hop is a VLESS websocket session
+// opened inside the tunnel of the previous one, so each relay only knows its neighbours.
+func (app *App) dialOnion(ctx context.Context, relays []string, host string, port int, timeout time.Duration) (net.Conn, error) {
+	var tunnel net.Conn
+	for i, relay := range relays {
+		u, uid, err := global.ParseOnionRelay(relay)
+		if err != nil {
+			return nil, fmt.Errorf("onion relay %d: %w", i, err)
+		}
+		dialer := &websocket.Dialer{HandshakeTimeout: timeout}
+		if tunnel != nil {
+			inner := tunnel
+			dialer.NetDialContext = func(context.Context, string, string) (net.Conn, error) {
+				return inner, nil
+			}
+		}
+		ws, _, err := dialer.DialContext(ctx, u.String(), nil)
+		if err != nil {
+			if tunnel != nil {
+				tunnel.Close()
+			}
+			return nil, fmt.Errorf("onion relay %d %s: %w", i, u.Host, err)
+		}
+
+		nextHost, nextPort := host, strconv.Itoa(port)
+		if i+1 < len(relays) {
+			next, _, err := global.ParseOnionRelay(relays[i+1])
+			if err != nil {
+				ws.Close()
+				return nil, fmt.Errorf("onion relay %d: %w", i+1, err)
+			}
+			nextHost, nextPort = next.Hostname(), next.Port()
+			if nextPort == "" {
+				nextPort = "80"
+				if next.Scheme == "wss" {
+					nextPort = "443"
+				}
+			}
+		}
+		portN, err := strconv.ParseUint(nextPort, 10, 16)
+		if err != nil {
+			ws.Close()
+			return nil, fmt.Errorf("onion hop port %q: %w", nextPort, err)
+		}
+		stream := &wsNetConn{wsStream: newWsStream(ws, nil, app.config().WriteTimeout())}
+		if _, err := stream.Write(schema.VlessRequest(uid, "tcp", nextHost, uint16(portN))); err != nil {
+			ws.Close()
+			return nil, fmt.Errorf("onion relay %d %s: %w", i, u.Host, err)
+		}
+		tunnel = &vlessClientConn{Conn: stream}
+	}
+	return tunnel, nil
+}
+
+// wsNetConn is a wsStream usable as the net.Conn of the next hop
+type wsNetConn struct {
+	*wsStream
+}
+
+func (c *wsNetConn) LocalAddr() net.Addr  { return c.ws.LocalAddr() }
+func (c *wsNetConn) RemoteAddr() net.Addr { return c.ws.RemoteAddr() }
+func (c *wsNetConn) SetDeadline(t time.Time) error {
+	return errors.Join(c.ws.SetReadDeadline(t), c.ws.SetWriteDeadline(t))
+}
+func (c *wsNetConn) SetReadDeadline(t time.Time) error  { return c.ws.SetReadDeadline(t) }
+func (c *wsNetConn) SetWriteDeadline(t time.Time) error { return c.ws.SetWriteDeadline(t) }
+
+// vlessClientConn strips the VLESS response header off the first bytes read from the relay
+type vlessClientConn struct {
+	net.Conn
+	headerRead bool
+}
+
+func (c *vlessClientConn) Read(p []byte) (int, error) {
+	if !c.headerRead {
+		header := make([]byte, 2) //version and addons length
+		if _, err := io.ReadFull(c.Conn, header); err != nil {
+			return 0, err
+		}
+		if _, err := io.CopyN(io.Discard, c.Conn, int64(header[1])); err != nil {
+			return 0, err
+		}
+		c.headerRead = true
+	}
+	return c.Conn.Read(p)
+}
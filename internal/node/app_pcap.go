@@ -0,0 +1,138 @@
+package node
+
+import (
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// pcapSegmentSize splits large reads and writes into packets wireshark displays without complaints
+const pcapSegmentSize = 1400
+
+// pcapDump writes the proxied bytes of all tcp sessions as fake tcp packets to one pcap file.
+// It is only for debugging, the file contains the cleartext of every session.
+type pcapDump struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *pcapgo.Writer
+}
+
+func newPCAPDump(path string) (*pcapDump, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("creating pcap file: %w", err)
+	}
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("writing pcap header: %w", err)
+	}
+	return &pcapDump{f: f, w: w}, nil
+}
+
+// pcapEndpoint is one side of a captured session
+type pcapEndpoint struct {
+	ip   net.IP
+	port uint16
+	seq  uint32
+}
+
+func newPCAPEndpoint(addr string) *pcapEndpoint {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	port, _ := strconv.Atoi(portStr)
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ip = net.IPv4zero
+	}
+	return &pcapEndpoint{ip: ip, port: uint16(port)}
+}
+
+var (
+	pcapSrcMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	pcapDstMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+)
+
+func (d *pcapDump) write(src, dst *pcapEndpoint, payload []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for len(payload) > 0 {
+		segment := payload[:min(len(payload), pcapSegmentSize)]
+		payload = payload[len(segment):]
+		if err := d.writeSegment(src, dst, segment); err != nil {
+			return err
+		}
+		src.seq += uint32(len(segment))
+	}
+	return nil
+}
+
+func (d *pcapDump) writeSegment(src, dst *pcapEndpoint, segment []byte) error {
+	eth := &layers.Ethernet{SrcMAC: pcapSrcMAC, DstMAC: pcapDstMAC}
+	tcp := &layers.TCP{SrcPort: layers.TCPPort(src.port), DstPort: layers.TCPPort(dst.port), Seq: src.seq, Ack: dst.seq, ACK: true, PSH: true, Window: 65535}
+	var ip gopacket.SerializableLayer
+	if src.ip.To4() != nil && dst.ip.To4() != nil {
+		eth.EthernetType = layers.EthernetTypeIPv4
+		ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: src.ip.To4(), DstIP: dst.ip.To4()}
+		tcp.SetNetworkLayerForChecksum(ip4)
+		ip = ip4
+	} else {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolTCP, SrcIP: src.ip.To16(), DstIP: dst.ip.To16()}
+		tcp.SetNetworkLayerForChecksum(ip6)
+		ip = ip6
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, tcp, gopacket.Payload(segment)); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+	return d.w.WritePacket(ci, data)
+}
+
+// pcapConn captures the bytes written to and read from the destination connection
+type pcapConn struct {
+	net.Conn
+	dump   *pcapDump
+	client *pcapEndpoint
+	target *pcapEndpoint
+}
+
+func (c *pcapConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.dump.write(c.client, c.target, p[:n])
+	}
+	return n, err
+}
+
+func (c *pcapConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.dump.write(c.target, c.client, p[:n])
+	}
+	return n, err
+}
+
+// capture wraps conn for DebugPCAPPath, clientAddr is the ip or ip:port of the websocket client
+func (app *App) capture(conn net.Conn, clientAddr string) net.Conn {
+	if app.pcap == nil {
+		return conn
+	}
+	return &pcapConn{
+		Conn:   conn,
+		dump:   app.pcap,
+		client: newPCAPEndpoint(clientAddr),
+		target: newPCAPEndpoint(conn.RemoteAddr().String()),
+	}
+}
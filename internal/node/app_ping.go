@@ -23,8 +23,8 @@ func (app *App) Ping(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	lines := []string{
-		"BUILT HASH:  https://github.com/unchainese/unchain/tree/" + app.cfg.GitHash,
-		"BUILT TIME:  " + app.cfg.BuildTime,
+		"BUILT HASH:  https://github.com/unchainese/unchain/tree/" + app.config().GitHash,
+		"BUILT TIME:  " + app.config().BuildTime,
 	}
 	w.Write([]byte(strings.Join(lines, "\n\n")))
 }
@@ -0,0 +1,49 @@
+package node
+
+import (
+	"bytes"
+	"context"
+)
+
+const (
+	protocolHTTP1   = "http/1.x"
+	protocolHTTP2   = "http/2"
+	protocolTLS     = "tls"
+	protocolUnknown = "unknown"
+)
+
+// protocolPeekSize is enough for the longest signature, the http/2 connection preface
+const protocolPeekSize = 16
+
+var http1RequestPrefixes = [][]byte{
+	[]byte("GET /"), []byte("POST /"), []byte("PUT /"), []byte("DELETE /"), []byte("HEAD /"),
+	[]byte("OPTIONS "), []byte("PATCH /"), []byte("CONNECT "), []byte("TRACE /"),
+}
+
+// detectProtocol names the application protocol from the first bytes the client tunnels
+func detectProtocol(buf []byte) string {
+	buf = peek(buf, protocolPeekSize)
+	switch {
+	case bytes.HasPrefix(buf, []byte("PRI * HTTP/2")):
+		return protocolHTTP2
+	case len(buf) >= 3 && buf[0] == 0x16 && buf[1] == 0x03:
+		return protocolTLS
+	case isHTTP1Response(buf):
+		return protocolHTTP1
+	}
+	for _, prefix := range http1RequestPrefixes {
+		if bytes.HasPrefix(buf, prefix) {
+			return protocolHTTP1
+		}
+	}
+	return protocolUnknown
+}
+
+func withProtocol(ctx context.Context, protocol string) context.Context {
+	return context.WithValue(ctx, ctxKeyProtocol, protocol)
+}
+
+func protocolFrom(ctx context.Context) string {
+	p, _ := ctx.Value(ctxKeyProtocol).(string)
+	return p
+}
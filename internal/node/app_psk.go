@@ -0,0 +1,29 @@
+package node
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"strings"
+)
+
+// pskCost keeps the per upgrade hash comparison cheap, the key itself is not a user chosen password
+const pskCost = bcrypt.MinCost
+
+func hashPSK(psk string) ([]byte, error) {
+	if psk == "" {
+		return nil, nil
+	}
+	return bcrypt.GenerateFromPassword([]byte(psk), pskCost)
+}
+
+// pskAllowed reports whether r carries the pre-shared key, always true when no PSK is configured
+func (app *App) pskAllowed(r *http.Request) bool {
+	if app.pskHash == nil {
+		return true
+	}
+	key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "PSK ")
+	if !ok {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(app.pskHash, []byte(key)) == nil
+}
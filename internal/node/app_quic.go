@@ -0,0 +1,44 @@
+package node
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/quic-go/quic-go/http3"
+	"log"
+	"net/http"
+)
+
+// quicSvr prepares the http/3 server sharing the handlers of the tcp server.
+// Websocket upgrades need a hijackable tcp connection, so VLESS clients keep using tcp,
+// subscriptions and the other plain http endpoints are served over quic too.
+func (app *App) quicSvr() error {
+	c := app.config()
+	cert, err := tls.LoadX509KeyPair(c.QUICCertFile, c.QUICKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load quic key pair %s %s: %w", c.QUICCertFile, c.QUICKeyFile, err)
+	}
+	app.h3 = &http3.Server{
+		Addr:      c.ListenAddr,
+		Handler:   app.handler,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}),
+	}
+	return nil
+}
+
+// altSvc advertises the http/3 endpoint on the tcp responses
+func (app *App) altSvc(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := app.h3.SetQUICHeaders(w.Header()); err != nil {
+			app.logger.Debug("failed to set quic headers", "err", err)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *App) runQUIC() {
+	log.Println("server starting on quic://", app.config().ListenAddr)
+	if err := app.h3.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Println("Could not listen quic on", app.config().ListenAddr, err)
+	}
+}
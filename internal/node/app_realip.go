@@ -0,0 +1,41 @@
+package node
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// realIP is the client address, taken from the headers set by cloudflare or nginx in front of the node
+func realIP(r *http.Request) string {
+	if ip := strings.TrimSpace(r.Header.Get("CF-Connecting-IP")); ip != "" {
+		return ip
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		first, _, _ := strings.Cut(xff, ",")
+		if ip := strings.TrimSpace(first); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// forwardedHops are the addresses of every X-Forwarded-For header, client first
+func forwardedHops(r *http.Request) []string {
+	var hops []string
+	for _, xff := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(xff, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
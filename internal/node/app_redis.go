@@ -0,0 +1,67 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/redis/go-redis/v9"
+	"github.com/unchainese/unchain/internal/global"
+	"time"
+)
+
+// redis keys shared by all nodes of an active-active deployment
+const (
+	redisUsersKey         = "emissary:users"    //hash uid -> json UserMeta
+	redisTrafficKeyPrefix = "emissary:traffic:" //hash per uid, field kb
+	redisTimeout          = time.Millisecond * 500
+)
+
+func newRedisClient(cfg *global.Config) *redis.Client {
+	if cfg.RedisAddr == "" {
+		return nil
+	}
+	return redis.NewClient(&redis.Options{Addr: cfg.RedisAddr, Password: cfg.RedisPassword})
+}
+
+// loopRedisUsers refreshes the users of the redis hash in the background, handshakes answer from memory
+// and keep the last users while redis is unavailable. Hash values are the json UserMeta of the
+// register server, or a plain number for its value.
+func (app *App) loopRedisUsers() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		users, err := app.redis.HGetAll(ctx, redisUsersKey).Result()
+		cancel()
+		if err != nil {
+			app.logger.Error("Error loading users from redis:", "err", err)
+		} else {
+			loaded := make(map[string]UserMeta, len(users))
+			for uid, value := range users {
+				meta := UserMeta{Value: 1}
+				if err := json.Unmarshal([]byte(value), &meta); err != nil {
+					app.logger.Debug("redis user without json meta", "uid", uid, "err", err)
+				}
+				loaded[uid] = meta
+			}
+			app.mu.Lock()
+			app.redisUsers = loaded
+			app.mu.Unlock()
+		}
+		time.Sleep(app.config().PushInterval())
+	}
+}
+
+// pushRedisTraffic adds the drained traffic counters of stat to the shared traffic hashes
+func (app *App) pushRedisTraffic(traffic map[string]int64) {
+	if len(traffic) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	pipe := app.redis.Pipeline()
+	for uid, kb := range traffic {
+		pipe.HIncrBy(ctx, redisTrafficKeyPrefix+uid, "kb", kb)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		// the counters are already drained, they are lost for the shared total
+		app.logger.Error("Error pushing traffic to redis:", "err", err, "users", len(traffic))
+	}
+}
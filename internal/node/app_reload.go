@@ -0,0 +1,61 @@
+package node
+
+import (
+	"github.com/unchainese/unchain/internal/global"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func (app *App) loopReload(path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		app.reloadConfig(path)
+		app.loadHosts()
+	}
+}
+
+// reloadConfig applies the mutable fields of the config file to the running node.
+// Fields that need a restart (eg. ListenAddr) are only reported when they changed.
+func (app *App) reloadConfig(path string) {
+	c, err := global.Load(path)
+	if err != nil {
+		app.logger.Error("failed to reload config", "file", path, "err", err)
+		return
+	}
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	old := app.cfg
+	if c.ListenAddr != old.ListenAddr {
+		app.logger.Warn("ListenAddr changed, restart to apply", "old", old.ListenAddr, "new", c.ListenAddr)
+	}
+	if c.LogFile != old.LogFile {
+		app.logger.Warn("LogFile changed, restart to apply", "old", old.LogFile, "new", c.LogFile)
+	}
+
+	next := *old
+	next.SubAddresses = c.SubAddresses
+	next.RegisterUrl = c.RegisterUrl
+	next.RegisterToken = c.RegisterToken
+	next.NodeTags = c.NodeTags
+	next.PushIntervalSecond = c.PushIntervalSecond
+	next.AllowUsers = c.AllowUsers
+	next.DebugLevel = c.DebugLevel
+	next.GlobalMaxBandwidthMbps = c.GlobalMaxBandwidthMbps
+	next.ExtraHostsFile = c.ExtraHostsFile
+	app.cfg = &next
+	setBandwidth(app.bandwidth, next.GlobalBandwidthBytes())
+	slog.SetLogLoggerLevel(next.LogLevel())
+
+	// users of a registered node are owned by the register server, see PushNode
+	if next.RegisterUrl == "" {
+		users := make(map[string]UserMeta)
+		for _, userID := range next.UserIDS() {
+			users[userID] = UserMeta{Value: 1}
+		}
+		app.allowedUsers = users
+	}
+	app.logger.Info("config reloaded", "file", path)
+}
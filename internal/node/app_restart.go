@@ -0,0 +1,94 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// envListenerFD tells a restarted process which inherited file descriptor is the listener
+const envListenerFD = "UNCHAIN_LISTENER_FD"
+
+// inheritedListener recreates the listener passed by the parent process, ok is false when there is none
+func inheritedListener() (ln net.Listener, ok bool, err error) {
+	v := os.Getenv(envListenerFD)
+	if v == "" {
+		return nil, false, nil
+	}
+	os.Unsetenv(envListenerFD)
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, true, fmt.Errorf("invalid %s %q: %w", envListenerFD, v, err)
+	}
+	f := os.NewFile(uintptr(fd), "listener")
+	defer f.Close()
+	ln, err = net.FileListener(f)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to import inherited listener: %w", err)
+	}
+	return ln, true, nil
+}
+
+func (app *App) listen() (net.Listener, error) {
+	ln, ok, err := inheritedListener()
+	if ok {
+		if err == nil {
+			app.logger.Info("serving on the listener inherited from the parent process", "addr", ln.Addr().String())
+		}
+		return ln, err
+	}
+	return net.Listen("tcp", app.config().ListenAddr)
+}
+
+// forkWithListener starts a copy of this process which serves on the same listening socket
+func (app *App) forkWithListener() (*os.Process, error) {
+	app.mu.Lock()
+	ln := app.listener
+	app.mu.Unlock()
+	tcpLn, ok := ln.(*net.TCPListener)
+	if !ok {
+		return nil, errors.New("no tcp listener to hand over")
+	}
+	f, err := tcpLn.File()
+	if err != nil {
+		return nil, fmt.Errorf("failed to export listener: %w", err)
+	}
+	defer f.Close()
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{f}
+	//ExtraFiles[i] becomes file descriptor 3+i in the child
+	cmd.Env = append(os.Environ(), envListenerFD+"=3")
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start new process: %w", err)
+	}
+	return cmd.Process, nil
+}
+
+// loopRestart hands the listener to a new process on SIGUSR2,
+// then shuts this process down gracefully so its connections close naturally.
+func (app *App) loopRestart() {
+	ch := make(chan os.Signal, 1)
+	notifyRestart(ch)
+	for range ch {
+		proc, err := app.forkWithListener()
+		if err != nil {
+			app.logger.Error("graceful restart failed", "err", err)
+			continue
+		}
+		app.logger.Info("graceful restart, new process started", "pid", proc.Pid)
+		if app.exitSignal != nil {
+			app.exitSignal <- syscall.SIGTERM
+		}
+		return
+	}
+}
@@ -0,0 +1,77 @@
+package node
+
+import (
+	"time"
+)
+
+const (
+	headerSessionID     = "X-Session-ID"
+	headerResumeSession = "X-Resume-Session"
+	sessionResumeTTL    = time.Minute * 5
+)
+
+// resumableSession is what a closed websocket leaves for the reconnect of a mobile client
+type resumableSession struct {
+	uid       string
+	bytesUp   int64
+	bytesDown int64
+	unbilled  int64 //bytes of the session below a whole KB, not yet in the per user traffic
+	expires   time.Time
+}
+
+// suspendSession keeps the counters of entry and the unbilled bytes of the session under id for sessionResumeTTL
+func (app *App) suspendSession(id string, entry *connEntry, unbilled int64) {
+	entry.mu.Lock()
+	uid := entry.uid
+	entry.mu.Unlock()
+	app.resumable.Store(id, &resumableSession{
+		uid:       uid,
+		bytesUp:   entry.bytesUp.Load(),
+		bytesDown: entry.bytesDown.Load(),
+		unbilled:  unbilled,
+		expires:   time.Now().Add(sessionResumeTTL),
+	})
+}
+
+// resumeSession takes the suspended session id of uid, a session resumes once and only for its own user
+func (app *App) resumeSession(id, uid string) *resumableSession {
+	if id == "" {
+		return nil
+	}
+	v, ok := app.resumable.Load(id)
+	if !ok {
+		return nil
+	}
+	s := v.(*resumableSession)
+	if s.uid != uid || time.Now().After(s.expires) || !app.resumable.CompareAndDelete(id, v) {
+		return nil
+	}
+	return s
+}
+
+// trafficIncSession bills the bytes of a session that may resume: whole KBs now, the rest once the session
+// ends for good, so a session split by reconnects is billed like one connection. It returns the unbilled bytes.
+func (app *App) trafficIncSession(uid string, byteN int64, resumed *resumableSession) int64 {
+	total := byteN
+	if resumed != nil {
+		total += resumed.unbilled
+	}
+	app.trafficAdd(uid, byteN, total/1024)
+	return total % 1024
+}
+
+// loopPruneResumable bills and forgets the sessions that were not resumed in time
+func (app *App) loopPruneResumable() {
+	tk := time.NewTicker(time.Minute)
+	defer tk.Stop()
+	for range tk.C {
+		now := time.Now()
+		app.resumable.Range(func(key, value any) bool {
+			s := value.(*resumableSession)
+			if now.After(s.expires) && app.resumable.CompareAndDelete(key, value) {
+				app.trafficAdd(s.uid, 0, 1) //the floor KB of trafficInc
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package node
+
+import (
+	"github.com/unchainese/unchain/internal/global"
+	"github.com/unchainese/unchain/internal/schema"
+	"net"
+	"strconv"
+)
+
+// rewriteTarget returns the address to dial for vd, TargetRewriteRules may redirect it
+func (app *App) rewriteTarget(cfg *global.Config, vd *schema.ProtoVLESS) (string, int) {
+	from := vd.HostPort()
+	to, ok := cfg.TargetRewriteRules[from]
+	if !ok {
+		return vd.Host(), vd.Port()
+	}
+	host, portStr, err := net.SplitHostPort(to)
+	if err != nil {
+		return vd.Host(), vd.Port()
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		app.logger.Error("Error parsing rewrite port:", "rule", from, "err", err)
+		return vd.Host(), vd.Port()
+	}
+	counterInc(&app.rewriteStats, from)
+	app.logger.Debug("target rewritten", "from", from, "to", to)
+	return host, port
+}
@@ -0,0 +1,56 @@
+package node
+
+import (
+	"slices"
+)
+
+// userSession is the ip a user is connected from with its connections, see SingleSessionPerUser
+type userSession struct {
+	ip      string
+	connIDs []string
+}
+
+// claimSession adds connID to the session of uid and reports whether it may proceed.
+// A session of uid from another ip is a conflict, SessionConflictPolicy rejects the new one or kicks the old.
+func (app *App) claimSession(uid, ip, connID string) bool {
+	app.sessionMu.Lock()
+	defer app.sessionMu.Unlock()
+	v, ok := app.activeSessionIPs.Load(uid)
+	if !ok {
+		app.activeSessionIPs.Store(uid, &userSession{ip: ip, connIDs: []string{connID}})
+		return true
+	}
+	session := v.(*userSession)
+	if session.ip == ip {
+		session.connIDs = append(session.connIDs, connID)
+		return true
+	}
+	if app.config().SessionConflictPolicy != "kick_old" {
+		app.logger.Warn("rejecting session from a second ip", "uid", uid, "ip", ip, "active_ip", session.ip)
+		return false
+	}
+	app.logger.Warn("kicking session from another ip", "uid", uid, "ip", ip, "kicked_ip", session.ip, "conns", len(session.connIDs))
+	for _, id := range session.connIDs {
+		if e, ok := app.connRegistry.Load(id); ok {
+			e.(*connEntry).setCloseReason(closeSessionKicked)
+			e.(*connEntry).close()
+		}
+	}
+	app.activeSessionIPs.Store(uid, &userSession{ip: ip, connIDs: []string{connID}})
+	return true
+}
+
+// releaseSession removes connID from the session of uid, the session ends with its last connection
+func (app *App) releaseSession(uid, connID string) {
+	app.sessionMu.Lock()
+	defer app.sessionMu.Unlock()
+	v, ok := app.activeSessionIPs.Load(uid)
+	if !ok {
+		return
+	}
+	session := v.(*userSession)
+	session.connIDs = slices.DeleteFunc(session.connIDs, func(id string) bool { return id == connID })
+	if len(session.connIDs) == 0 {
+		app.activeSessionIPs.Delete(uid)
+	}
+}
@@ -0,0 +1,60 @@
+package node
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const headerSessionToken = "X-Session-Token"
+
+// sessionTokenMAC signs uid and the session start, a token is "<unix start>.<hex hmac-sha256>"
+func sessionTokenMAC(secret, uid, start string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(uid + "." + start))
+	return mac.Sum(nil)
+}
+
+// sessionToken returns the X-Session-Token of the request when it was signed for uid with SessionTokenSecret.
+// The token only correlates connections in logs and the admin api, a bad one is ignored rather than rejected.
+func (app *App) sessionToken(r *http.Request, uid string) string {
+	secret := app.config().SessionTokenSecret
+	token := r.Header.Get(headerSessionToken)
+	if secret == "" || token == "" {
+		return ""
+	}
+	start, sig, ok := strings.Cut(token, ".")
+	if _, err := strconv.ParseInt(start, 10, 64); !ok || err != nil {
+		return ""
+	}
+	mac, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, sessionTokenMAC(secret, uid, start)) {
+		return ""
+	}
+	return token
+}
+
+func withSessionToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, ctxKeySessionToken, token)
+}
+
+func sessionTokenFrom(ctx context.Context) string {
+	token, _ := ctx.Value(ctxKeySessionToken).(string)
+	return token
+}
+
+// SessionConnections lists the active connections opened with the session token
+func (app *App) SessionConnections(token string) []ConnectionInfo {
+	list := make([]ConnectionInfo, 0)
+	app.connRegistry.Range(func(_, value any) bool {
+		if entry := value.(*connEntry); entry.sessionToken == token {
+			list = append(list, entry.info())
+		}
+		return true
+	})
+	return list
+}
@@ -0,0 +1,21 @@
+package node
+
+import (
+	"net"
+	"strings"
+)
+
+// hostsRelated reports whether a and b are the same host or one is a subdomain of the other
+func hostsRelated(a, b string) bool {
+	a = strings.TrimSuffix(strings.ToLower(a), ".")
+	b = strings.TrimSuffix(strings.ToLower(b), ".")
+	return a == b || strings.HasSuffix(a, "."+b) || strings.HasSuffix(b, "."+a)
+}
+
+// stripPort returns the host of a Host header, which may come without a port
+func stripPort(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return hostport
+}
@@ -0,0 +1,104 @@
+package node
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	spikeHistoryHours = 7 * 24
+	spikeFactor       = 3
+)
+
+// trafficHistory is the KB of a user in hourly buckets of the past 7 days and in the current minute
+type trafficHistory struct {
+	hours     [spikeHistoryHours]int64
+	hourOf    [spikeHistoryHours]int64 //unix hour the bucket belongs to
+	firstHour int64
+	minute    int64 //unix minute of minuteKB
+	minuteKB  int64
+}
+
+func (h *trafficHistory) add(now time.Time, kb int64) {
+	hour, minute := now.Unix()/3600, now.Unix()/60
+	i := hour % spikeHistoryHours
+	if h.hourOf[i] != hour {
+		h.hourOf[i], h.hours[i] = hour, 0
+	}
+	h.hours[i] += kb
+	if h.minute != minute {
+		h.minute, h.minuteKB = minute, 0
+	}
+	h.minuteKB += kb
+}
+
+// hourlyAverage is the mean KB of the complete hours seen within the past 7 days
+func (h *trafficHistory) hourlyAverage(now time.Time) float64 {
+	hour := now.Unix() / 3600
+	hours := min(hour-h.firstHour, spikeHistoryHours-1)
+	if hours <= 0 {
+		return 0
+	}
+	var sum int64
+	for i, kb := range h.hours {
+		if age := hour - h.hourOf[i]; age > 0 && age < spikeHistoryHours {
+			sum += kb
+		}
+	}
+	return float64(sum) / float64(hours)
+}
+
+type spikeState struct {
+	until   time.Time
+	limiter *rate.Limiter
+}
+
+// detectSpike adds kb to the history of uid and throttles the user when the current minute
+// exceeds spikeFactor times the hourly average. Traffic is counted when a session ends,
+// so a long session shows up in the minute it closes.
+func (app *App) detectSpike(uid string, kb int64) {
+	cfg := app.config()
+	now := time.Now()
+	app.spikeMu.Lock()
+	defer app.spikeMu.Unlock()
+	if app.trafficHistory == nil {
+		app.trafficHistory = make(map[string]*trafficHistory)
+		app.spikeLimited = make(map[string]*spikeState)
+	}
+	h, ok := app.trafficHistory[uid]
+	if !ok {
+		h = &trafficHistory{firstHour: now.Unix() / 3600}
+		app.trafficHistory[uid] = h
+	}
+	h.add(now, kb)
+	avg := h.hourlyAverage(now)
+	if avg <= 0 || float64(h.minuteKB) <= avg*spikeFactor {
+		return
+	}
+	if state, ok := app.spikeLimited[uid]; ok && now.Before(state.until) {
+		return
+	}
+	app.spikeLimited[uid] = &spikeState{
+		until:   now.Add(cfg.SpikeThrottleDuration()),
+		limiter: newBandwidthLimiter(cfg.SpikeThrottleBytes()),
+	}
+	app.logger.Warn("traffic spike, throttling user", "uid", uid, "minute_kb", h.minuteKB, "hourly_avg_kb", int64(avg),
+		"mbps", cfg.SpikeThrottleMbps, "duration", cfg.SpikeThrottleDuration())
+}
+
+// spikeLimiter returns the throttle of a user in a traffic spike, nil when the user is not throttled
+func (app *App) spikeLimiter(uid string) *rate.Limiter {
+	app.spikeMu.Lock()
+	defer app.spikeMu.Unlock()
+	state, ok := app.spikeLimited[uid]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(state.until) {
+		delete(app.spikeLimited, uid)
+		app.logger.Info("traffic spike throttle lifted", "uid", uid)
+		return nil
+	}
+	return state.limiter
+}
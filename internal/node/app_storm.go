@@ -0,0 +1,35 @@
+package node
+
+import (
+	"golang.org/x/time/rate"
+	"time"
+)
+
+const (
+	stormBackoffMin = time.Millisecond
+	stormBackoffMax = time.Second
+)
+
+// stormBackoff slows down new websocket connections while more than StormThreshold arrive per second.
+// The delay starts at 1ms and doubles up to 1s, it is reset once the rate is back under the threshold.
+func (app *App) stormBackoff() {
+	threshold := app.config().StormThreshold
+	if threshold <= 0 {
+		return
+	}
+	app.stormOnce.Do(func() {
+		app.stormLimiter = rate.NewLimiter(rate.Limit(threshold), threshold)
+	})
+	if app.stormLimiter.Allow() {
+		if app.acceptBackoff.Swap(0) > 0 {
+			app.logger.Info("storm protection deactivated")
+		}
+		return
+	}
+	backoff := time.Duration(app.acceptBackoff.Load())
+	next := min(max(backoff*2, stormBackoffMin), stormBackoffMax)
+	if app.acceptBackoff.CompareAndSwap(int64(backoff), int64(next)) && backoff == 0 {
+		app.logger.Warn("storm protection activated", "threshold", threshold)
+	}
+	time.Sleep(next)
+}
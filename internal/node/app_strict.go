@@ -0,0 +1,31 @@
+package node
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/google/uuid"
+)
+
+// looksLikeVLESS reports whether buf starts with vless version 0 and the first uuid bytes of an allowed user,
+// it rejects http, smtp and other protocols sent straight into the websocket.
+func (app *App) looksLikeVLESS(buf []byte) bool {
+	if len(buf) < 5 || buf[0] != 0x00 {
+		return false
+	}
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	for _, users := range []map[string]UserMeta{app.allowedUsers, app.redisUsers} {
+		for userID := range users {
+			u, err := uuid.Parse(userID)
+			if err == nil && bytes.Equal(u[:4], buf[1:5]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hexPrefix is the hex of the first 16 bytes of buf for logging
+func hexPrefix(buf []byte) string {
+	return hex.EncodeToString(peek(buf, 16))
+}
@@ -53,8 +53,15 @@ func (s vlessSub) vlessURL(hostSni string, isTLS bool) string {
 
 func (app *App) Sub(w http.ResponseWriter, r *http.Request) {
 	uid := r.PathValue("uid")
-	if app.IsUserNotAllowed(uid) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if !app.subAllowed(w, r, uid) {
+		return
+	}
+	switch r.URL.Query().Get("format") {
+	case "pac":
+		app.subPAC(w)
+		return
+	case "qr":
+		app.subQR(w, r, uid)
 		return
 	}
 	subURLs := app.vlessUrls(uid)
@@ -64,22 +71,36 @@ func (app *App) Sub(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 
 	lines := []string{
-		app.cfg.GitHash,
-		app.cfg.BuildTime,
+		app.config().GitHash,
+		app.config().BuildTime,
 		"VLESS Subscription URL:",
 	}
 	lines = append(lines, subURLs...)
 	w.Write([]byte(strings.Join(lines, "\n\n")))
 }
 
+// subAllowed answers the request of a user that is not allowed and reports whether the subscription may be served
+func (app *App) subAllowed(w http.ResponseWriter, r *http.Request, uid string) bool {
+	if !app.IsUserNotAllowed(uid) {
+		return true
+	}
+	if app.config().ProbeResistance {
+		app.Decoy(w, r)
+		return false
+	}
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	return false
+}
+
 func (app *App) vlessUrls(uid string) []string {
 	var subURLs []string
-	for _, subAddr := range app.cfg.SubAddresses {
+	cfg := app.config()
+	for _, subAddr := range cfg.SubAddresses {
 		sub := vlessSub{
 			remark:       subAddr,
 			addrWithPort: subAddr,
 			UID:          uid,
-			path:         "/wsv/" + uid + "?ed=2560",
+			path:         cfg.SubPath(subAddr, uid) + "?ed=2560",
 		}
 		isTLS := strings.HasSuffix(subAddr, ":443")
 		subURL := sub.vlessURL("", isTLS)
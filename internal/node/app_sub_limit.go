@@ -0,0 +1,67 @@
+package node
+
+import (
+	"golang.org/x/time/rate"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	subRequestsPerMinute = 10
+	subLimiterPruneEvery = time.Minute * 5
+)
+
+type subLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen atomic.Int64 //unix seconds
+}
+
+// allowSub applies the per ip token bucket of the subscription endpoint,
+// retryAfter is how long the client has to wait when it is not allowed.
+func (app *App) allowSub(ip string) (ok bool, retryAfter time.Duration) {
+	v, found := app.subLimiters.Load(ip)
+	if !found {
+		v, _ = app.subLimiters.LoadOrStore(ip, &subLimiter{
+			limiter: rate.NewLimiter(rate.Every(time.Minute/subRequestsPerMinute), subRequestsPerMinute),
+		})
+	}
+	l := v.(*subLimiter)
+	l.lastSeen.Store(time.Now().Unix())
+	reservation := l.limiter.Reserve()
+	delay := reservation.Delay()
+	if delay == 0 {
+		return true, 0
+	}
+	reservation.Cancel()
+	return false, delay
+}
+
+func (app *App) subRateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, retryAfter := app.allowSub(realIP(r))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// loopPruneSubLimiters forgets the ips that stopped asking, their buckets are full again anyway
+func (app *App) loopPruneSubLimiters() {
+	tk := time.NewTicker(subLimiterPruneEvery)
+	defer tk.Stop()
+	for range tk.C {
+		deadline := time.Now().Add(-subLimiterPruneEvery).Unix()
+		app.subLimiters.Range(func(key, value any) bool {
+			if value.(*subLimiter).lastSeen.Load() < deadline {
+				app.subLimiters.Delete(key)
+			}
+			return true
+		})
+	}
+}
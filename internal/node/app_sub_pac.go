@@ -0,0 +1,44 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const pacTemplate = `var proxy = %s;
+var domains = %s;
+
+function FindProxyForURL(url, host) {
+	host = host.toLowerCase();
+	for (var i = 0; i < domains.length; i++) {
+		var d = domains[i];
+		if (host === d || dnsDomainIs(host, "." + d)) {
+			return proxy;
+		}
+	}
+	return "DIRECT";
+}
+`
+
+// pacFile routes domains and their subdomains through the socks5 proxy at proxyAddr, the rest goes DIRECT
+func pacFile(domains []string, proxyAddr string) string {
+	if domains == nil {
+		domains = []string{}
+	}
+	// json literals are valid javascript and escape whatever the config contains
+	proxy, _ := json.Marshal(fmt.Sprintf("SOCKS5 %s; SOCKS %s; DIRECT", proxyAddr, proxyAddr))
+	list, _ := json.Marshal(domains)
+	return fmt.Sprintf(pacTemplate, proxy, list)
+}
+
+func (app *App) subPAC(w http.ResponseWriter) {
+	cfg := app.config()
+	proxyAddr := cfg.PACProxyAddr
+	if proxyAddr == "" {
+		proxyAddr = "127.0.0.1:1080"
+	}
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(pacFile(cfg.PACRoutedDomains, proxyAddr)))
+}
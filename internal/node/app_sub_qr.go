@@ -0,0 +1,61 @@
+package node
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const qrSize = 256 //pixels
+
+// subQR serves the index-th subscription url as a png qr code for mobile clients to scan
+func (app *App) subQR(w http.ResponseWriter, r *http.Request, uid string) {
+	subURLs := app.vlessUrls(uid)
+	index, err := strconv.Atoi(r.URL.Query().Get("index"))
+	if err != nil || index < 0 || index >= len(subURLs) {
+		http.Error(w, "index out of range", http.StatusNotFound)
+		return
+	}
+	png, err := qrcode.Encode(subURLs[index], qrcode.Medium, qrSize)
+	if err != nil {
+		app.logger.Error("Error encoding qr code:", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(png)
+}
+
+var subQRPage = template.Must(template.New("qr").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width"><title>VLESS Subscription</title></head>
+<body>
+{{range .}}<figure><img src="{{.Src}}" width="256" height="256" alt="{{.Remark}}"><figcaption>{{.Remark}}</figcaption></figure>
+{{end}}</body>
+</html>
+`))
+
+// SubQRPage lists the qr codes of all subscription urls of the user
+func (app *App) SubQRPage(w http.ResponseWriter, r *http.Request) {
+	uid := r.PathValue("uid")
+	if !app.subAllowed(w, r, uid) {
+		return
+	}
+	type qrImage struct{ Src, Remark string }
+	var images []qrImage
+	for i, subAddr := range app.config().SubAddresses {
+		src := "/sub/" + url.PathEscape(uid) + "?format=qr&index=" + strconv.Itoa(i)
+		images = append(images, qrImage{Src: src, Remark: subAddr})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if err := subQRPage.Execute(w, images); err != nil {
+		app.logger.Error("Error rendering qr page:", "err", err)
+	}
+}
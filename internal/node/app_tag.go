@@ -0,0 +1,28 @@
+package node
+
+import (
+	"context"
+)
+
+const maxTagLen = 32
+
+// sanitizeTag keeps the letters, digits and hyphens of a client supplied ?tag= label, at most maxTagLen of them
+func sanitizeTag(tag string) string {
+	b := make([]byte, 0, min(len(tag), maxTagLen))
+	for i := 0; i < len(tag) && len(b) < maxTagLen; i++ {
+		c := tag[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' {
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
+
+func withTag(ctx context.Context, tag string) context.Context {
+	return context.WithValue(ctx, ctxKeyTag, tag)
+}
+
+func tagFrom(ctx context.Context) string {
+	tag, _ := ctx.Value(ctxKeyTag).(string)
+	return tag
+}
@@ -0,0 +1,110 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	threatListedTTL   = time.Hour
+	threatUnlistedTTL = time.Minute * 10
+	threatLookupLimit = time.Second * 2 //a slow feed must not stall the handshakes
+)
+
+type threatEntry struct {
+	listed bool
+	expiry time.Time
+}
+
+// ipListed reports whether ThreatFeedURL lists the client ip, results are cached in app.threatCache.
+// Lookup failures let the client in and are not cached.
+func (app *App) ipListed(ctx context.Context, ip string) bool {
+	cfg := app.config()
+	if cfg.ThreatFeedURL == "" || net.ParseIP(ip) == nil {
+		return false
+	}
+	if v, ok := app.threatCache.Load(ip); ok {
+		entry := v.(threatEntry)
+		if time.Now().Before(entry.expiry) {
+			return entry.listed
+		}
+		app.threatCache.Delete(ip)
+	}
+	ctx, cancel := context.WithTimeout(ctx, threatLookupLimit)
+	defer cancel()
+	var listed bool
+	var err error
+	if cfg.ThreatFeedType == "http" {
+		listed, err = app.threatHTTP(ctx, cfg.ThreatFeedURL, ip)
+	} else {
+		listed, err = app.threatDNSBL(ctx, cfg.ThreatFeedURL, ip)
+	}
+	if err != nil {
+		app.logger.Error("Error checking threat feed:", "err", err, "ip", ip)
+		return false
+	}
+	ttl := threatUnlistedTTL
+	if listed {
+		ttl = threatListedTTL
+	}
+	app.threatCache.Store(ip, threatEntry{listed: listed, expiry: time.Now().Add(ttl)})
+	return listed
+}
+
+// threatDNSBL queries <reversed ip>.<zone>, any A record means listed (RFC 5782)
+func (app *App) threatDNSBL(ctx context.Context, zone, ip string) (bool, error) {
+	_, err := app.resolver.LookupHost(ctx, dnsblName(ip, zone))
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// dnsblName reverses the octets of an ipv4 or the nibbles of an ipv6 address in front of zone
+func dnsblName(ip, zone string) string {
+	addr := net.ParseIP(ip)
+	var labels []string
+	if v4 := addr.To4(); v4 != nil {
+		for i := len(v4) - 1; i >= 0; i-- {
+			labels = append(labels, fmt.Sprint(v4[i]))
+		}
+	} else {
+		for i := len(addr) - 1; i >= 0; i-- {
+			labels = append(labels, fmt.Sprintf("%x", addr[i]&0xf), fmt.Sprintf("%x", addr[i]>>4))
+		}
+	}
+	return strings.Join(labels, ".") + "." + strings.TrimSuffix(zone, ".")
+}
+
+// threatHTTP posts {"ip": ip} to the api, which answers {"listed": bool}
+func (app *App) threatHTTP(ctx context.Context, url, ip string) (bool, error) {
+	body, _ := json.Marshal(map[string]string{"ip": ip})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.pushClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("threat feed responded %s", resp.Status)
+	}
+	var result struct {
+		Listed bool `json:"listed"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("decoding threat feed response: %w", err)
+	}
+	return result.Listed, nil
+}
@@ -0,0 +1,129 @@
+package node
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// certStore holds the served certificate, which can be swapped while the server is running
+type certStore struct {
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	certFile string
+	keyFile  string
+}
+
+func newCertStore(certFile, keyFile string) (*certStore, error) {
+	s := &certStore{certFile: certFile, keyFile: keyFile}
+	return s, s.load()
+}
+
+func (s *certStore) load() error {
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load tls key pair %s %s: %w", s.certFile, s.keyFile, err)
+	}
+	s.mu.Lock()
+	s.cert = &cert
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *certStore) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert, nil
+}
+
+type getCertificateFunc func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+// watchCertificate wraps a GetCertificate callback (certStore, autocert.Manager ...)
+// and reports every time it starts returning a different leaf certificate.
+func (app *App) watchCertificate(get getCertificateFunc) getCertificateFunc {
+	var mu sync.Mutex
+	var served []byte
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := get(hello)
+		if err != nil || cert == nil || len(cert.Certificate) == 0 {
+			return cert, err
+		}
+		leaf := cert.Certificate[0]
+		mu.Lock()
+		changed := served != nil && !bytes.Equal(served, leaf)
+		served = leaf
+		mu.Unlock()
+		if changed {
+			go app.certRotated(leaf)
+		}
+		return cert, nil
+	}
+}
+
+func (app *App) certRotated(leaf []byte) {
+	sum := sha256.Sum256(leaf)
+	fingerprint := hex.EncodeToString(sum[:])
+	event := map[string]any{"event": "cert_rotated", "sha256": fingerprint, "time": time.Now()}
+	if x, err := x509.ParseCertificate(leaf); err == nil {
+		event["subject"] = x.Subject.String()
+		event["not_after"] = x.NotAfter
+	}
+	app.logger.Info("tls certificate rotated", "sha256", fingerprint)
+
+	url := app.config().CertRotationWebhook
+	if url == "" {
+		return
+	}
+	body := bytes.NewBuffer(nil)
+	if err := json.NewEncoder(body).Encode(event); err != nil {
+		app.logger.Error("failed to encode cert rotation event", "err", err)
+		return
+	}
+	resp, err := http.Post(url, "application/json", body)
+	if err != nil {
+		app.logger.Error("failed to call cert rotation webhook", "url", url, "err", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func (app *App) tlsConfig() *tls.Config {
+	cfg := app.config()
+	tc := &tls.Config{
+		GetCertificate: app.watchCertificate(app.certs.GetCertificate),
+	}
+	if cfg.UsePostQuantumKEM {
+		tc.CurvePreferences = postQuantumCurves
+	}
+	if cfg.RejectTLS12 {
+		tc.MinVersion = tls.VersionTLS13
+	} else if cfg.LogTLS12 {
+		tc.VerifyConnection = func(cs tls.ConnectionState) error {
+			if cs.Version == tls.VersionTLS12 {
+				app.logger.Warn("tls 1.2 negotiated, possible downgrade", "sni", cs.ServerName, "cipher", tls.CipherSuiteName(cs.CipherSuite))
+			}
+			return nil
+		}
+	}
+	return tc
+}
+
+func (app *App) loopCertReload() {
+	ch := make(chan os.Signal, 1)
+	notifyCertReload(ch)
+	for range ch {
+		if err := app.certs.load(); err != nil {
+			app.logger.Error("failed to rotate tls certificate", "err", err)
+			continue
+		}
+		app.logger.Info("tls certificate reloaded from disk", "cert", app.certs.certFile)
+	}
+}
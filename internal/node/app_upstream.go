@@ -0,0 +1,88 @@
+package node
+
+import (
+	"github.com/unchainese/unchain/internal/global"
+	"github.com/unchainese/unchain/internal/schema"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// upstreamWriter builds the client -> destination write path of a tcp session.
+// The returned close func must be called once the client side is done.
+func (app *App) upstreamWriter(sv *schema.ProtoVLESS, conn net.Conn, logger *slog.Logger) (io.Writer, func()) {
+	cfg := app.config()
+	var upstream io.Writer = conn
+	var closers []func()
+
+	if cfg.WriteBufferSize > 0 {
+		cw := newCoalescingWriter(upstream, cfg.WriteBufferSize, cfg.WriteBufferFlushInterval())
+		closers = append(closers, func() { cw.Flush() })
+		upstream = cw
+	}
+
+	if rewrites := httpRewrites(cfg, sv); len(rewrites) > 0 && isHTTP1Request(sv.DataTcp()) {
+		dst := upstream
+		pr, pw := io.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			err := rewriteHTTP1Request(pr, dst, func(req *http.Request) error {
+				for _, rewrite := range rewrites {
+					if err := rewrite(req); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				logger.Error("Error rewriting http request:", "err", err)
+				conn.Close()
+			}
+			pr.CloseWithError(err)
+		}()
+		// the rewriter must drain into dst before the writers behind it are flushed,
+		// one stuck writing to a destination that stopped reading is unblocked by closing conn
+		closers = append([]func(){func() {
+			pw.Close()
+			select {
+			case <-done:
+			case <-time.After(cfg.WriteTimeout()):
+				conn.Close()
+				<-done
+			}
+		}}, closers...)
+		upstream = pw
+	}
+
+	return upstream, func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+}
+
+// httpRewrites are the rewrites of the http/1.x requests of a session, see rewriteHTTP1Request
+func httpRewrites(cfg *global.Config, sv *schema.ProtoVLESS) []func(req *http.Request) error {
+	var rewrites []func(req *http.Request) error
+	if cfg.StripChunkedEncoding {
+		rewrites = append(rewrites, dechunkRequest)
+	}
+	if len(cfg.InjectHeaders) > 0 {
+		rewrites = append(rewrites, func(req *http.Request) error {
+			for k, v := range cfg.InjectHeaders {
+				req.Header.Set(k, v)
+			}
+			return nil
+		})
+	}
+	if traceID := sv.Addon("trace-id"); traceID != "" && cfg.InjectTraceID {
+		rewrites = append(rewrites, func(req *http.Request) error {
+			req.Header.Set("X-Trace-Id", traceID)
+			return nil
+		})
+	}
+	return rewrites
+}
@@ -0,0 +1,72 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// UserMeta is what the register server knows about an allowed user.
+// Older register servers send a plain number per user, it is kept in Value.
+type UserMeta struct {
+	Value          int64     `json:"value"`
+	DailyTrafficKB int64     `json:"daily_traffic_kb"` //average
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func (m *UserMeta) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] != '{' && !bytes.Equal(b, []byte("null")) {
+		return json.Unmarshal(b, &m.Value)
+	}
+	type plain UserMeta
+	return json.Unmarshal(b, (*plain)(m))
+}
+
+// ConnectionScore ranks users for admission under pressure, from 0 to 1.
+// Old accounts with steady traffic score high, every active connection of the user divides the score.
+func (app *App) ConnectionScore(uid string) float64 {
+	app.mu.Lock()
+	meta, ok := app.lookupUser(uid)
+	app.mu.Unlock()
+	if !ok {
+		return 0
+	}
+	age := 0.0
+	if !meta.CreatedAt.IsZero() {
+		age = math.Min(time.Since(meta.CreatedAt).Hours()/24/365, 1)
+	}
+	// 10GB a day is the top of the traffic score
+	traffic := math.Min(math.Log10(1+float64(max(meta.DailyTrafficKB, 0)))/7, 1)
+	return (age + traffic) / 2 / float64(1+app.activeConnsOf(uid))
+}
+
+func (app *App) activeConnsOf(uid string) int {
+	n := 0
+	app.connRegistry.Range(func(_, value any) bool {
+		e := value.(*connEntry)
+		e.mu.Lock()
+		if e.uid == uid {
+			n++
+		}
+		e.mu.Unlock()
+		return true
+	})
+	return n
+}
+
+// admitConnection reports whether a new connection of uid is accepted, see MaxConcurrentConns
+func (app *App) admitConnection(uid string) bool {
+	cfg := app.config()
+	if cfg.MaxConcurrentConns <= 0 {
+		return true
+	}
+	active := app.activeConns.Load()
+	if active >= int64(cfg.MaxConcurrentConns) {
+		return false
+	}
+	if float64(active) <= 0.9*float64(cfg.MaxConcurrentConns) {
+		return true
+	}
+	return app.ConnectionScore(uid) >= cfg.PressureMinScore
+}
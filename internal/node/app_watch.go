@@ -0,0 +1,47 @@
+package node
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"path/filepath"
+	"time"
+)
+
+// configWatchDebounce collapses the burst of events editors emit for a single save
+const configWatchDebounce = 200 * time.Millisecond
+
+// loopWatchConfig reloads the config file when it is written, in addition to SIGHUP.
+// The directory is watched because editors often replace the file by renaming.
+func (app *App) loopWatchConfig(path string) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		app.logger.Error("Error creating config watcher:", "err", err)
+		return
+	}
+	defer watcher.Close()
+	path = filepath.Clean(path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		app.logger.Error("Error watching config file:", "file", path, "err", err)
+		return
+	}
+	var debounce *time.Timer
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != path || !event.Has(fsnotify.Write|fsnotify.Create) {
+				continue
+			}
+			if debounce != nil {
+				debounce.Stop()
+			}
+			debounce = time.AfterFunc(configWatchDebounce, func() { app.reloadConfig(path) })
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			app.logger.Error("Error watching config file:", "file", path, "err", err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package node
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// startWatchdog pings the client every TunnelWatchdogInterval and closes the websocket when no pong
+// arrives within TunnelWatchdogTimeout, a tunnel silently dropped by a middlebox never sees an EOF.
+// Pongs are handled by the reads of the copy loops, stop ends the watchdog.
+func (app *App) startWatchdog(ws *websocket.Conn, entry *connEntry, logger *slog.Logger) (stop func()) {
+	cfg := app.config()
+	interval, timeout := cfg.TunnelWatchdogInterval(), cfg.TunnelWatchdogTimeout()
+	if interval <= 0 {
+		return func() {}
+	}
+	var lastPong atomic.Int64
+	ws.SetPongHandler(func(string) error {
+		lastPong.Store(time.Now().UnixNano())
+		return nil
+	})
+	done := make(chan struct{})
+	go func() {
+		tk := time.NewTicker(interval)
+		defer tk.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tk.C:
+			}
+			sent := time.Now()
+			if err := ws.WriteControl(websocket.PingMessage, nil, sent.Add(timeout)); err != nil {
+				return
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(timeout):
+			}
+			if lastPong.Load() < sent.UnixNano() {
+				logger.Warn("closing stuck connection, watchdog ping unanswered", "timeout", timeout)
+				entry.setCloseReason(closeWatchdogTimeout)
+				ws.Close()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
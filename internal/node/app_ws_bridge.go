@@ -0,0 +1,142 @@
+package node
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// isBridgeTargetAllowed matches host:port against AllowedBridgeTargets, no targets means no bridge
+func (app *App) isBridgeTargetAllowed(hostPort string) bool {
+	for _, pattern := range app.config().AllowedBridgeTargets {
+		if ok, err := path.Match(pattern, hostPort); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// WsBridge pipes raw bytes between the websocket and a tcp target, for clients without VLESS support
+func (app *App) WsBridge(w http.ResponseWriter, r *http.Request) {
+	app.reqInc()
+	if !app.admitRequest(w, r) {
+		return
+	}
+	uid := r.PathValue("uid")
+	hostPort := net.JoinHostPort(r.PathValue("host"), r.PathValue("port"))
+	if !app.isBridgeTargetAllowed(hostPort) {
+		app.logger.Warn("bridge target not allowed", "uid", uid, "addr", hostPort)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	connID := uuid.NewString()
+	ctx := withRemoteIP(withConnID(r.Context(), connID), realIP(r))
+	logger := app.connLogger(ctx).With("userID", uid, "network", "bridge", "addr", hostPort)
+	release, err := app.admitUser(uid, realIP(r), connID, true, logger)
+	if err != nil {
+		http.Error(w, http.StatusText(admissionStatus(err)), admissionStatus(err))
+		return
+	}
+	defer release()
+
+	respHeader := http.Header{"X-Connection-ID": {connID}}
+	draining := app.draining.Load()
+	if draining {
+		respHeader.Set("X-Server-Closing", "true")
+	}
+	ws, err := app.upgrader.Upgrade(w, r, respHeader)
+	if err != nil {
+		logger.Error("Error upgrading to websocket:", "err", err)
+		return
+	}
+	defer ws.Close()
+	entry := &connEntry{connID: connID, uid: uid, remoteIP: realIP(r), target: hostPort, close: ws.Close}
+	app.connOpen(entry)
+	defer app.connClose(connID)
+	if draining {
+		closeTimer := time.AfterFunc(app.config().DrainingCloseAfter(), func() {
+			entry.setCloseReason(closeServerShutdown)
+			ws.Close()
+		})
+		defer closeTimer.Stop()
+	}
+
+	conn, err := net.DialTimeout("tcp", hostPort, time.Second)
+	if err != nil {
+		entry.setCloseReason(closeDialFailed)
+		logger.Error("Error connecting to bridge target:", "err", err)
+		closeWs(ws, websocket.CloseInternalServerErr, "bridge target unreachable")
+		return
+	}
+	defer conn.Close()
+	logger.Info("Session started bridge")
+
+	throttle := app.sessionThrottle(app.config(), uid)
+	var trafficMeter atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer conn.Close()
+		for {
+			mt, message, err := ws.ReadMessage()
+			trafficMeter.Add(int64(len(message)))
+			entry.addUp(int64(len(message)))
+			if err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+					logger.Error("Error reading message:", "err", err)
+				}
+				return
+			}
+			if mt != websocket.BinaryMessage {
+				continue
+			}
+			if err := throttle(ctx, len(message)); err != nil {
+				logger.Error("Error waiting for bandwidth:", "err", err)
+				return
+			}
+			if _, err = conn.Write(message); err != nil {
+				logger.Error("Error writing to TCP connection:", "err", err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		defer ws.Close()
+		bufPtr := app.getBuffer(buffSize)
+		defer app.putBuffer(bufPtr)
+		buf := *bufPtr
+		for {
+			n, err := conn.Read(buf)
+			trafficMeter.Add(int64(n))
+			entry.addDown(int64(n))
+			if n > 0 {
+				if err := throttle(ctx, n); err != nil {
+					logger.Error("Error waiting for bandwidth:", "err", err)
+					return
+				}
+				if err := writeWs(ws, buf[:n], app.config().WriteTimeout()); err != nil {
+					logger.Error("Error writing to websocket:", "err", err)
+					return
+				}
+			}
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				logger.Error("Error reading from TCP connection:", "err", err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	app.trafficInc(uid, trafficMeter.Load())
+}
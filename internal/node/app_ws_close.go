@@ -0,0 +1,28 @@
+package node
+
+import (
+	"github.com/gorilla/websocket"
+	"time"
+)
+
+// writeWs sends a binary message, the deadline is renewed for every message
+// so a slow client can not hold the writing goroutine forever
+func writeWs(ws *websocket.Conn, data []byte, timeout time.Duration) error {
+	ws.SetWriteDeadline(time.Now().Add(timeout))
+	return ws.WriteMessage(websocket.BinaryMessage, data)
+}
+
+// closeWsSoft sends the close frame and gives the client wait to answer it before closing the websocket,
+// the read loop of the session ends with the answer
+func closeWsSoft(ws *websocket.Conn, code int, reason string, wait time.Duration) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	time.AfterFunc(wait, func() { ws.Close() })
+}
+
+// closeWs sends the close frame with code and reason before closing the websocket
+func closeWs(ws *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	ws.Close()
+}
@@ -0,0 +1,157 @@
+package node
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/unchainese/unchain/internal/schema"
+	"github.com/xtaci/smux"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const smuxCmdSYN = 0x00
+
+// isSmuxFrame reports whether the first message opens a smux session instead of a VLESS request,
+// smux frames start with version 1 or 2, a VLESS request with version 0.
+func isSmuxFrame(buf []byte) bool {
+	return len(buf) >= 8 && (buf[0] == 1 || buf[0] == 2) && buf[1] == smuxCmdSYN
+}
+
+// vlessSmux serves every smux stream of the websocket as an independent VLESS tcp session,
+// all streams must belong to the user of the first one.
+func (app *App) vlessSmux(ctx context.Context, ws *websocket.Conn, first []byte) {
+	cfg := smux.DefaultConfig()
+	cfg.Version = int(first[0])
+	session, err := smux.Server(newWsStream(ws, first, app.config().WriteTimeout()), cfg)
+	if err != nil {
+		app.connLogger(ctx).Error("Error starting smux session:", "err", err)
+		return
+	}
+	defer session.Close()
+
+	entry := app.connEntryFrom(ctx)
+	var owner string
+	var ownerMu sync.Mutex
+	isOwner := func(uid string) bool {
+		ownerMu.Lock()
+		defer ownerMu.Unlock()
+		if owner == "" {
+			owner = uid
+			if entry != nil {
+				entry.setUID(uid)
+			}
+		}
+		return owner == uid
+	}
+
+	var wg sync.WaitGroup
+	for {
+		stream, err := session.AcceptStream()
+		if err != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// net/http only recovers the handler goroutine, a panic here would end the node
+			defer func() {
+				if p := recover(); p != nil {
+					app.connLogger(ctx).Error("Error serving smux stream:", "panic", p, "stream", stream.ID())
+					stream.Close()
+				}
+			}()
+			app.smuxStream(ctx, stream, isOwner)
+		}()
+	}
+	wg.Wait()
+}
+
+func (app *App) smuxStream(ctx context.Context, stream *smux.Stream, isOwner func(uid string) bool) {
+	defer stream.Close()
+	buf := make([]byte, buffSize)
+	stream.SetReadDeadline(time.Now().Add(time.Second * 5))
+	n, err := stream.Read(buf)
+	if err != nil {
+		app.connLogger(ctx).Error("Error reading smux stream header:", "err", err)
+		return
+	}
+	stream.SetReadDeadline(time.Time{})
+	if app.config().StrictVLESSValidation && !app.looksLikeVLESS(buf[:n]) {
+		app.connLogger(ctx).Warn("closing smux stream, first bytes are not vless", "bytes", hexPrefix(buf[:n]), "stream", stream.ID())
+		return
+	}
+	sv, err := schema.VlessParse(buf[:n])
+	if err != nil {
+		app.connLogger(ctx).Error("Error parsing vless data:", "err", err)
+		return
+	}
+	// every stream is a connection of its own for the registry, the admission and the session of the user
+	sessionConnID := connIDFrom(ctx)
+	ctx = withConnID(ctx, uuid.NewString())
+	logger := app.connLogger(ctx).With(sv.LogArgs()...).With("stream", stream.ID())
+	release, err := app.admitUser(sv.UUID(), remoteIPFrom(ctx), connIDFrom(ctx), true, logger)
+	if err != nil {
+		return
+	}
+	defer release()
+	if !isOwner(sv.UUID()) {
+		return
+	}
+	entry := &connEntry{connID: connIDFrom(ctx), uid: sv.UUID(), remoteIP: remoteIPFrom(ctx), target: sv.HostPort(), close: stream.Close}
+	app.connOpen(entry)
+	defer app.connClose(entry.connID)
+	defer func() {
+		logger.Info("connection closed", "reason", entry.closeReason(), "target", entry.target, "smux_conn_id", sessionConnID)
+	}()
+	protocol := detectProtocol(sv.DataTcp())
+	counterInc(&app.protocolStats, protocol)
+	ctx = withProtocol(ctx, protocol)
+	logger = logger.With("protocol", protocol)
+	if sv.DstProtocol != "tcp" {
+		logger.Error("Error unsupported smux stream protocol")
+		return
+	}
+	conn, headerVLESS, err := app.startDstConnection(ctx, sv, time.Millisecond*1000)
+	if err != nil {
+		entry.setCloseReason(closeDialFailed)
+		logger.Error("Error starting session:", "err", err)
+		return
+	}
+	defer conn.Close()
+	logger.Info("Session started smux tcp")
+
+	var trafficMeter atomic.Int64
+	trafficMeter.Add(int64(n))
+	upstream, closeUpstream := app.upstreamWriter(sv, conn, logger)
+	if _, err := upstream.Write(sv.DataTcp()); err != nil {
+		logger.Error("Error writing early data to TCP connection:", "err", err)
+		closeUpstream()
+		return
+	}
+	if _, err := stream.Write(headerVLESS); err != nil {
+		closeUpstream()
+		return
+	}
+
+	throttle := app.sessionThrottle(app.config(), sv.UUID())
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer conn.Close()
+		defer closeUpstream()
+		n, _ := io.Copy(countingWriter{w: throttledWriter{ctx, throttle, upstream}, n: &trafficMeter}, stream)
+		app.connEntryFrom(ctx).addUp(n)
+	}()
+	go func() {
+		defer wg.Done()
+		defer stream.Close()
+		n, _ := io.Copy(countingWriter{w: throttledWriter{ctx, throttle, stream}, n: &trafficMeter}, conn)
+		app.connEntryFrom(ctx).addDown(n)
+	}()
+	wg.Wait()
+	app.trafficInc(sv.UUID(), trafficMeter.Load())
+}
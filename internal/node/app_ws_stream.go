@@ -0,0 +1,64 @@
+package node
+
+import (
+	"github.com/gorilla/websocket"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// wsStream adapts the message based websocket to an io.ReadWriteCloser byte stream
+type wsStream struct {
+	ws      *websocket.Conn
+	timeout time.Duration //write timeout
+	pending []byte        //rest of the last message, starts with the already read first message
+	wmu     sync.Mutex
+}
+
+func newWsStream(ws *websocket.Conn, first []byte, writeTimeout time.Duration) *wsStream {
+	return &wsStream{ws: ws, pending: first, timeout: writeTimeout}
+}
+
+func (s *wsStream) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		mt, message, err := s.ws.ReadMessage()
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		if mt == websocket.BinaryMessage {
+			s.pending = message
+		}
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *wsStream) Write(p []byte) (int, error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if err := writeWs(s.ws, p, s.timeout); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *wsStream) Close() error {
+	return s.ws.Close()
+}
+
+// countingWriter adds the written byte count to a traffic meter
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
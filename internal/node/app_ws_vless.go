@@ -1,17 +1,22 @@
 package node
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/unchainese/unchain/internal/global"
 	"github.com/unchainese/unchain/internal/schema"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -19,28 +24,105 @@ import (
 
 const buffSize = 8 << 10
 
-var upGrader = websocket.Upgrader{
-	ReadBufferSize:  buffSize,
-	WriteBufferSize: buffSize,
-	CheckOrigin: func(r *http.Request) bool {
-		// Allow all connections by default
-		return true
-	},
+func newUpgrader(c *global.Config) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:  c.UpstreamReadBufferSize(),
+		WriteBufferSize: c.DownstreamReadBufferSize(),
+		CheckOrigin: func(r *http.Request) bool {
+			// Allow all connections by default
+			return true
+		},
+	}
 }
 
-func startDstConnection(vd *schema.ProtoVLESS, timeout time.Duration) (net.Conn, []byte, error) {
-	conn, err := net.DialTimeout(vd.DstProtocol, vd.HostPort(), timeout)
+// sniPeekSize is how many bytes of the first payload are inspected for a TLS ClientHello
+const sniPeekSize = 512
+
+func (app *App) startDstConnection(ctx context.Context, vd *schema.ProtoVLESS, timeout time.Duration) (net.Conn, []byte, error) {
+	cfg := app.config()
+	host, port := app.rewriteTarget(cfg, vd)
+	if cfg.PreserveSNI && vd.DstProtocol == "tcp" {
+		host = app.sniTarget(ctx, host, vd.DataTcp())
+	}
+	if len(cfg.UpstreamServers) > 0 {
+		servers := regionalUpstreams(cfg, countryFrom(ctx))
+		var server string
+		if cfg.LatencyRouting {
+			server = app.fastestUpstream(servers, vd.Host())
+		} else if cfg.AffinityByIP {
+			server = pickUpstream(servers, remoteIPFrom(ctx))
+		} else {
+			server = pickUpstream(servers, vd.UUID())
+		}
+		app.connEntryFrom(ctx).setUpstream(server)
+		host, port = splitUpstream(server)
+	}
+	var conn net.Conn
+	var err error
+	if len(cfg.OnionRelays) > 0 {
+		if vd.DstProtocol != "tcp" {
+			return nil, nil, errors.New("udp is not supported through OnionRelays")
+		}
+		conn, err = app.dialOnion(ctx, cfg.OnionRelays, host, port, timeout)
+	} else {
+		conn, err = app.dial(context.Background(), vd.DstProtocol, host, port, timeout)
+	}
+	app.recordDial(vd.HostPort(), err)
 	if err != nil {
 		return nil, nil, fmt.Errorf("connecting to destination: %w", err)
 	}
-	return conn, []byte{vd.Version, 0x00}, nil
+	if vd.DstProtocol == "tcp" && cfg.UseTLSEgress && cfg.IsTLSEgressPort(vd.Port()) && !isTLSRecord(vd.DataTcp()) {
+		serverName := vd.Host()
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: serverName, VerifyPeerCertificate: app.verifyCertPins(serverName)})
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("connecting to tls destination %s: %w", serverName, err)
+		}
+		tlsConn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
+	return conn, app.responseHeader(ctx, vd.Version, nil), nil
+}
+
+// sniTarget is the host dialed for a tcp payload starting with a ClientHello. The tls of the client
+// is piped untouched, the server name only replaces an ip target and is checked against a domain one.
+func (app *App) sniTarget(ctx context.Context, host string, payload []byte) string {
+	serverName, _ := schema.TLSServerName(peek(payload, sniPeekSize))
+	if serverName == "" {
+		return host
+	}
+	if net.ParseIP(host) != nil {
+		return serverName
+	}
+	if !hostsRelated(host, serverName) {
+		app.connLogger(ctx).Warn("client hello server name unrelated to vless target", "server_name", serverName, "target", host)
+	}
+	return host
+}
+
+// isTLSRecord reports whether the payload starts with a tls handshake record, the client speaks tls itself
+func isTLSRecord(buf []byte) bool {
+	return len(buf) >= 2 && buf[0] == 0x16 && buf[1] == 0x03
+}
+
+func peek(buf []byte, n int) []byte {
+	if len(buf) < n {
+		return buf
+	}
+	return buf[:n]
 }
 
 func (app *App) WsVLESS(w http.ResponseWriter, r *http.Request) {
 	app.reqInc()
+	app.stormBackoff()
 	uid := r.PathValue("uid")
 	//check can upgrade websocket
 	if r.Header.Get("Upgrade") != "websocket" {
+		if app.config().LongPollingFallback && isLongPoll(r) {
+			app.WsLongPoll(w, r)
+			return
+		}
 		//json response hello world
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -49,65 +131,263 @@ func (app *App) WsVLESS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := r.Context()
+	if !app.admitRequest(w, r) {
+		return
+	}
+
+	connID := uuid.NewString()
+	version := clientVersion(r)
+	counterInc(&app.clientStats, version)
+	ctx := withClientVersion(withRemoteIP(withConnID(r.Context(), connID), realIP(r)), version)
+	ctx = withCountry(ctx, clientCountry(r))
+	if r.URL.Query().Get("inject-config") == "1" {
+		ctx = withInjectConfig(ctx)
+	}
+	if tag := sanitizeTag(r.URL.Query().Get("tag")); tag != "" {
+		counterInc(&app.tagStats, tag)
+		ctx = withTag(ctx, tag)
+	}
+	logger := app.connLogger(ctx)
+	logger.Debug("client connected", "client_version", version)
 	earlyDataHeader := r.Header.Get("sec-websocket-protocol")
 	earlyData, err := base64.RawURLEncoding.DecodeString(earlyDataHeader)
 	if err != nil {
-		log.Println("Error decoding early data:", err)
+		logger.Error("Error decoding early data:", "err", err)
 	}
 
-	ws, err := upGrader.Upgrade(w, r, nil)
+	respHeader := http.Header{"X-Connection-ID": {connID}}
+	sessionID := ""
+	if app.config().SessionResumption {
+		// not the conn id, which shows up in logs and the admin api
+		sessionID = uuid.NewString()
+		respHeader.Set(headerSessionID, sessionID)
+	}
+	var migration *migratingSession
+	if app.config().MigrationEnabled {
+		if cookie := r.Header.Get(headerMigrationCookie); cookie != "" {
+			var status int
+			if migration, status = app.claimMigration(cookie, r.Header.Get(headerMigrationOffset), r.Header.Get(headerMigrationProof)); migration == nil {
+				logger.Warn("rejecting migration", "status", status)
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+			respHeader.Set(headerMigrationOffset, strconv.FormatInt(migration.received.Load(), 10))
+		} else {
+			migration = newMigratingSession()
+		}
+		defer app.releaseMigration(migration)
+		respHeader.Set(headerMigrationCookie, migration.cookie)
+	}
+	draining := app.draining.Load()
+	if draining {
+		respHeader.Set("X-Server-Closing", "true")
+	}
+	ws, err := app.upgrader.Upgrade(w, r, respHeader)
 	if err != nil {
-		fmt.Println("Error upgrading to websocket:", err)
+		logger.Error("Error upgrading to websocket:", "err", err)
 		return
 	}
 	defer ws.Close()
+	if app.config().DisableNagle {
+		setNoDelay(ws.NetConn())
+	}
+	if draining {
+		closeTimer := time.AfterFunc(app.config().DrainingCloseAfter(), func() {
+			app.connEntryFrom(ctx).setCloseReason(closeServerShutdown)
+			ws.Close()
+		})
+		defer closeTimer.Stop()
+	}
 
 	if len(earlyData) == 0 {
+		timeout := app.config().HandshakeTimeout()
+		ws.SetReadDeadline(time.Now().Add(timeout))
 		mt, p, err := ws.ReadMessage()
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			logger.Warn("closing connection without vless handshake", "timeout", timeout, "remote_ip", realIP(r))
+			closeWs(ws, websocket.CloseProtocolError, "handshake timeout")
+			return
+		}
 		if err != nil {
-			log.Println("Error reading message:", err)
+			logger.Error("Error reading message:", "err", err)
 			return
 		}
+		ws.SetReadDeadline(time.Time{})
 		if mt == websocket.BinaryMessage {
 			earlyData = p
+			if app.config().ObfuscationBlockSize > 0 {
+				if earlyData, err = unpadMessage(p); err != nil {
+					logger.Error("Error unpadding message:", "err", err)
+					return
+				}
+			}
 		}
 	}
 
+	if app.config().SmuxEnabled && isSmuxFrame(earlyData) {
+		app.connOpen(&connEntry{connID: connID, remoteIP: realIP(r), target: "smux", close: ws.Close})
+		defer app.connClose(connID)
+		app.vlessSmux(ctx, ws, earlyData)
+		return
+	}
+
+	if app.config().StrictVLESSValidation && !app.looksLikeVLESS(earlyData) {
+		logger.Warn("closing websocket, first message is not vless", "bytes", hexPrefix(earlyData), "remote_ip", realIP(r))
+		closeWs(ws, websocket.ClosePolicyViolation, "")
+		return
+	}
 	vData, err := schema.VlessParse(earlyData)
 	if err != nil {
-		log.Println("Error parsing vless data:", err)
+		logger.Error("Error parsing vless data:", "err", err)
+		return
+	}
+	if flow := vData.Flow(); flow != "" {
+		// flows like xtls-rprx-vision of xray clients are not implemented, every client gets the classic stream
+		logger.Debug("vless flow requested", "flow", flow, "client", clientFamily(version))
+	}
+	if traceID := vData.Addon("trace-id"); traceID != "" {
+		ctx = withTraceID(ctx, traceID)
+		logger = app.connLogger(ctx)
+	}
+	if app.isDiagnosticUser(vData.UUID()) {
+		app.vlessDiagnostic(ctx, vData, ws)
 		return
 	}
-	if app.IsUserNotAllowed(vData.UUID()) {
+	if token := app.sessionToken(r, vData.UUID()); token != "" {
+		ctx = withSessionToken(ctx, token)
+		logger = app.connLogger(ctx)
+	}
+	migrated := migration.destination() != nil
+	if migrated && migration.owner() != vData.UUID() {
+		logger.Warn("rejecting migration of another user", "uid", vData.UUID())
+		closeWs(ws, websocket.ClosePolicyViolation, "")
 		return
 	}
+	var resumed *resumableSession
+	if sessionID != "" {
+		resumed = app.resumeSession(r.Header.Get(headerResumeSession), vData.UUID())
+	}
+	release, err := app.admitUser(vData.UUID(), realIP(r), connID, resumed == nil && !migrated, logger)
+	if err != nil {
+		closeWsAdmission(ws, err)
+		return
+	}
+	defer release()
+	if resumed != nil {
+		logger.Info("session resumed", "uid", vData.UUID())
+	}
+	if app.config().LogSNIMismatch {
+		if host := stripPort(r.Host); !hostsRelated(host, vData.Host()) {
+			app.sniMismatch.Add(1)
+			logger.Warn("vless target unrelated to websocket host", "host", host, "target", vData.Host())
+		}
+	}
+	entry := &connEntry{connID: connID, uid: vData.UUID(), remoteIP: realIP(r), target: vData.HostPort(), close: ws.Close, sessionToken: sessionTokenFrom(ctx)}
+	if resumed != nil {
+		entry.bytesUp.Store(resumed.bytesUp)
+		entry.bytesDown.Store(resumed.bytesDown)
+		entry.lastTotal = resumed.bytesUp + resumed.bytesDown //not bandwidth of this connection
+	}
+	var unbilled int64
+	if resumed != nil {
+		unbilled = resumed.unbilled
+	}
+	app.connOpen(entry)
+	defer app.connClose(connID)
+	defer func() {
+		logger.Info("connection closed", "reason", entry.closeReason(), "target", entry.target, "score", app.scoreConnection(entry))
+		if sessionID != "" {
+			app.suspendSession(sessionID, entry, unbilled)
+		}
+	}()
+	if d := app.config().MaxSessionDuration(); d > 0 {
+		sessionTimer := time.AfterFunc(d, func() {
+			entry.setCloseReason(closeMaxDuration)
+			closeWsSoft(ws, websocket.CloseGoingAway, "max session duration", time.Second*5)
+		})
+		defer sessionTimer.Stop()
+	}
+	defer app.startWatchdog(ws, entry, logger)()
+	defer app.startNoise(ws)()
 
 	sessionTrafficByteN := int64(len(earlyData))
 
 	if vData.DstProtocol == "udp" {
-		sessionTrafficByteN += vlessUDP(ctx, vData, ws)
+		sessionTrafficByteN += app.vlessUDP(ctx, vData, ws)
 	} else if vData.DstProtocol == "tcp" {
-		sessionTrafficByteN += vlessTCP(ctx, vData, ws)
-	}else{
-		log.Println("Error unsupported protocol:", vData.DstProtocol)
+		sessionTrafficByteN += app.vlessTCP(ctx, vData, ws, migration)
+	} else {
+		logger.Error("Error unsupported protocol:", "network", vData.DstProtocol)
+		return
+	}
+	if sessionID != "" {
+		unbilled = app.trafficIncSession(vData.UUID(), sessionTrafficByteN, resumed)
 		return
 	}
 	app.trafficInc(vData.UUID(), sessionTrafficByteN)
 }
 
-func vlessTCP(_ context.Context, sv *schema.ProtoVLESS, ws *websocket.Conn) int64 {
-	logger := sv.Logger()
-	conn, headerVLESS, err := startDstConnection(sv, time.Millisecond*1000)
-	if err != nil {
-		logger.Error("Error starting session:", "err", err)
-		return 0
+func (app *App) vlessTCP(ctx context.Context, sv *schema.ProtoVLESS, ws *websocket.Conn, migration *migratingSession) int64 {
+	protocol := detectProtocol(sv.DataTcp())
+	counterInc(&app.protocolStats, protocol)
+	ctx = withProtocol(ctx, protocol)
+	logger := app.connLogger(ctx).With(sv.LogArgs()...)
+	dialStart := time.Now()
+	entry := app.connEntryFrom(ctx)
+	conn := migration.destination()
+	migrated := conn != nil
+	var headerVLESS []byte
+	if migrated {
+		headerVLESS = app.responseHeader(ctx, sv.Version, nil)
+	} else {
+		var err error
+		conn, headerVLESS, err = app.startDstConnection(ctx, sv, time.Millisecond*1000)
+		if err != nil {
+			entry.setCloseReason(closeDialFailed)
+			logger.Error("Error starting session:", "err", err)
+			return 0
+		}
+	}
+	if migration != nil && len(httpRewrites(app.config(), sv)) > 0 && isHTTP1Request(sv.DataTcp()) {
+		// a websocket dropping mid request leaves the rewriter with half a body, the destination cannot be handed over
+		app.endMigration(migration)
+		migration = nil
+	}
+	var parking atomic.Bool
+	defer func() {
+		if parking.Load() && migrationParkable(entry) {
+			app.parkMigration(migration)
+			return
+		}
+		conn.Close()
+		app.endMigration(migration)
+	}()
+	if migration != nil {
+		pending, fromIP := app.attachMigration(migration, sv.UUID(), entry.remoteIP, conn, func() {
+			entry.setCloseReason(closeMigrated)
+			ws.Close()
+		})
+		if len(pending) > 0 {
+			conn = &replayConn{Conn: conn, pending: pending}
+		}
+		if migrated {
+			logger.Info("Session migrated tcp", "from_ip", fromIP, "replay_bytes", len(pending))
+		}
+	}
+	if !migrated {
+		logger.Info("Session started tcp")
+	}
+	if entry != nil {
+		conn = app.capture(conn, entry.remoteIP)
 	}
-	defer conn.Close()
-	logger.Info("Session started tcp")
 
+	upstream, closeUpstream := app.upstreamWriter(sv, conn, logger)
+	defer closeUpstream()
 	//write early data
-	_, err = conn.Write(sv.DataTcp())
+	entry.addUp(int64(len(sv.DataTcp())))
+	n, err := upstream.Write(sv.DataTcp())
+	migration.addReceived(int64(n))
 	if err != nil {
 		logger.Error("Error writing early data to TCP connection:", "err", err)
 		return 0
@@ -115,22 +395,111 @@ func vlessTCP(_ context.Context, sv *schema.ProtoVLESS, ws *websocket.Conn) int6
 	var trafficMeter atomic.Int64
 	var wg sync.WaitGroup
 	wg.Add(2)
+	cfg := app.config()
+	throttle := app.sessionThrottle(cfg, sv.UUID())
+	codec := app.compression(cfg, sv)
+	if codec != nil {
+		// tells the client its messages are compressed from now on
+		headerVLESS = app.responseHeader(ctx, sv.Version, map[string]string{zstdAddonKey: "1"})
+	}
+	// both goroutines write to ws, gorilla allows one writer at a time
+	var wsMu sync.Mutex
+	writeData := vlessWriter(ws, cfg)
+	write := func(data []byte) error {
+		wsMu.Lock()
+		defer wsMu.Unlock()
+		return writeData(data)
+	}
+	flow := newFlowControl(sv, cfg.FlowControlWindowBytes, func(mt int, data []byte) error {
+		wsMu.Lock()
+		defer wsMu.Unlock()
+		ws.SetWriteDeadline(time.Now().Add(cfg.WriteTimeout()))
+		return ws.WriteMessage(mt, data)
+	})
+	if flow != nil {
+		flow.update(flow.window) //the initial window
+	}
+	var classifier *trafficClassifier
+	if cfg.TrafficClassification {
+		classifier = newTrafficClassifier(func(class string, bytesPerSecond int64) {
+			counterInc(&app.trafficClasses, class)
+			entry.setTrafficClass(class)
+			logger.Debug("traffic classified", "class", class, "bytes_per_second", bytesPerSecond)
+			tuneSocket(ws.NetConn(), class)
+			if class == trafficInteractive {
+				setNoDelay(conn)
+			}
+		})
+		defer classifier.stop()
+	}
+	if d := cfg.StaleTimeout(); d > 0 {
+		// a connection that never made progress only holds a goroutine pair and a file descriptor
+		staleTimer := time.AfterFunc(d, func() {
+			if trafficMeter.Load() == 0 {
+				logger.Info("closing stale connection", "timeout", d)
+				entry.setCloseReason(closeIdleTimeout)
+				closeWs(ws, websocket.CloseGoingAway, "stale connection")
+				conn.Close()
+			}
+		})
+		defer staleTimer.Stop()
+	}
 	go func() {
 		defer wg.Done()
+		upBufPtr := app.getBuffer(cfg.UpstreamReadBufferSize())
+		defer app.putBuffer(upBufPtr)
+		upBuf := *upBufPtr
 		for {
-			mt, message, err := ws.ReadMessage()
-			trafficMeter.Add(int64(len(message)))
+			mt, message, err := ws.NextReader()
 			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
 				return
 			}
 			if err != nil {
 				logger.Error("Error reading message:", "err", err)
+				if migration != nil {
+					// wakes the other goroutine without closing the destination, the client may take it over
+					parking.Store(true)
+					conn.SetReadDeadline(time.Now())
+				}
 				return
 			}
 			if mt != websocket.BinaryMessage {
 				continue
 			}
-			_, err = conn.Write(message)
+			if cfg.ObfuscationBlockSize > 0 {
+				padded, err := io.ReadAll(message)
+				if err != nil {
+					logger.Error("Error reading message:", "err", err)
+					return
+				}
+				payload, err := unpadMessage(padded)
+				if err != nil {
+					logger.Error("Error unpadding message:", "err", err)
+					return
+				}
+				message = bytes.NewReader(payload)
+			}
+			if codec != nil {
+				compressed, err := io.ReadAll(message)
+				if err != nil {
+					logger.Error("Error reading message:", "err", err)
+					return
+				}
+				payload, err := app.decompress(codec, compressed)
+				if err != nil {
+					logger.Error("Error reading message:", "err", err)
+					return
+				}
+				message = bytes.NewReader(payload)
+			}
+			// throttledWriter also hides io.ReaderFrom of the upstream so the copy uses upBuf
+			n, err := flow.consumed(func() (int64, error) {
+				return io.CopyBuffer(throttledWriter{ctx, throttle, upstream}, message, upBuf)
+			})
+			trafficMeter.Add(n)
+			entry.addUp(n)
+			migration.addReceived(n)
+			classifier.observe(int(n))
 			if err != nil {
 				logger.Error("Error writing to TCP connection:", "err", err)
 				return
@@ -140,15 +509,27 @@ func vlessTCP(_ context.Context, sv *schema.ProtoVLESS, ws *websocket.Conn) int6
 
 	go func() {
 		defer wg.Done()
-		hasNotSentHeader := true
-		buf := make([]byte, buffSize)
+		hasNotSentHeader, messages := true, false
+		defer entry.setMessageWriter(nil)
+		bufPtr := app.getBuffer(cfg.DownstreamReadBufferSize())
+		defer func() { app.putBuffer(bufPtr) }()
+		buf := *bufPtr
+		bdp := newBDPEstimator()
 		for {
 			n, err := conn.Read(buf)
 			trafficMeter.Add(int64(n))
+			entry.addDown(int64(n))
+			classifier.observe(n)
+			migration.record(buf[:n])
+			if err != nil && parking.Load() {
+				return
+			}
 			if errors.Is(err, io.EOF) {
+				entry.setCloseReason(closeUpstreamClosed)
 				return
 			}
 			if err != nil {
+				entry.setCloseReason(closeUpstreamClosed)
 				logger.Error("Error reading from TCP connection:", "err", err)
 				return
 			}
@@ -156,23 +537,74 @@ func vlessTCP(_ context.Context, sv *schema.ProtoVLESS, ws *websocket.Conn) int6
 			// send header data only for the first time
 			if hasNotSentHeader {
 				hasNotSentHeader = false
-				data = append(headerVLESS, data...)
+				if !migrated {
+					app.recordTTFB(sv.Host(), time.Since(dialStart))
+					if upstream := entry.upstreamServer(); upstream != "" {
+						app.recordUpstreamLatency(upstream, sv.Host(), time.Since(dialStart))
+					}
+				}
+				if codec != nil {
+					data = append(headerVLESS, app.compress(codec, data)...)
+				} else {
+					data = append(headerVLESS, data...)
+				}
+			} else if codec != nil {
+				data = app.compress(codec, data)
+			}
+			if err := throttle(ctx, len(data)); err != nil {
+				logger.Error("Error waiting for bandwidth:", "err", err)
+				return
 			}
-			err = ws.WriteMessage(websocket.BinaryMessage, data)
+			writeStart := time.Now()
+			err = write(data)
 			if err != nil {
 				logger.Error("Error writing to websocket:", "err", err)
+				// data is recorded, a migrated client gets it again
+				parking.Store(migration != nil)
+				// unblock the reading goroutine of a client that stopped reading
+				ws.Close()
 				return
 			}
+			if !messages {
+				// message frames of BroadcastMessage may only follow the response header
+				messages = true
+				entry.setMessageWriter(func(text string) error {
+					frame, _ := messageFrame(sv.Version, text)
+					if codec != nil {
+						frame = app.compress(codec, frame)
+					}
+					return write(frame)
+				})
+			}
+			if bdp.observe(len(data), time.Since(writeStart)) {
+				entry.setEstimatedBandwidth(bdp.bandwidthMbps())
+				if size := bdp.bufferSize(len(buf)); cfg.AdaptiveBufferSize && size != len(buf) {
+					app.putBuffer(bufPtr)
+					bufPtr = app.getBuffer(size)
+					buf = *bufPtr
+				}
+			}
 		}
 	}()
 	wg.Wait()
 	return trafficMeter.Load()
 }
 
-func vlessUDP(_ context.Context, sv *schema.ProtoVLESS, ws *websocket.Conn) (trafficMeter int64) {
-	logger := sv.Logger()
-	conn, headerVLESS, err := startDstConnection(sv, time.Millisecond*1000)
+func (app *App) vlessUDP(ctx context.Context, sv *schema.ProtoVLESS, ws *websocket.Conn) (trafficMeter int64) {
+	logger := app.connLogger(ctx).With(sv.LogArgs()...)
+	entry := app.connEntryFrom(ctx)
+	entry.addUp(int64(len(sv.DataUdp())))
+	isDNS := sv.Port() == 53 && app.dnsResponses != nil
+	if isDNS {
+		if response, ok := app.dnsResponses.get(sv.DataUdp()); ok {
+			logger.Debug("dns response from cache")
+			entry.addDown(int64(len(response)))
+			return int64(len(sv.DataUdp())) + writeUDPResponse(vlessWriter(ws, app.config()), []byte{sv.Version, 0x00}, response, logger)
+		}
+	}
+	conn, headerVLESS, err := app.startDstConnection(ctx, sv, time.Millisecond*1000)
 	if err != nil {
+		entry.setCloseReason(closeDialFailed)
 		logger.Error("Error starting session:", "err", err)
 		return
 	}
@@ -185,22 +617,33 @@ func vlessUDP(_ context.Context, sv *schema.ProtoVLESS, ws *websocket.Conn) (tra
 		return
 	}
 
-	buf := make([]byte, buffSize)
+	bufPtr := app.getBuffer(buffSize)
+	defer app.putBuffer(bufPtr)
+	buf := *bufPtr
 	n, err := conn.Read(buf)
 	if err != nil {
 		logger.Error("Error reading from TCP connection:", "err", err)
 		return
 	}
+	if isDNS {
+		app.dnsResponses.put(buf[:n])
+	}
+	entry.setCloseReason(closeUpstreamClosed)
+	entry.addDown(int64(n))
+	return trafficMeter + writeUDPResponse(vlessWriter(ws, app.config()), headerVLESS, buf[:n], logger)
+}
+
+// writeUDPResponse sends a length prefixed udp packet to the client and returns the bytes sent
+func writeUDPResponse(write wsWriter, headerVLESS, packet []byte, logger *slog.Logger) int64 {
+	n := len(packet)
 	udpDataLen1 := (n >> 8) & 0xff
 	udpDataLen2 := n & 0xff
 	headerVLESS = append(headerVLESS, byte(udpDataLen1), byte(udpDataLen2))
-	headerVLESS = append(headerVLESS, buf[:n]...)
+	headerVLESS = append(headerVLESS, packet...)
 
-	trafficMeter += int64(len(headerVLESS))
-	err = ws.WriteMessage(websocket.BinaryMessage, headerVLESS)
+	err := write(headerVLESS)
 	if err != nil {
 		logger.Error("Error writing to websocket:", "err", err)
-		return
 	}
-	return trafficMeter
+	return int64(len(headerVLESS))
 }
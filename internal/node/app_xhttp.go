@@ -0,0 +1,158 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/unchainese/unchain/internal/schema"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WsXHTTP serves a VLESS tcp session over one streaming POST for CDNs that block websocket upgrades.
+// The request body carries client -> destination bytes, the chunked response destination -> client bytes.
+func (app *App) WsXHTTP(w http.ResponseWriter, r *http.Request) {
+	app.reqInc()
+	if !app.admitRequest(w, r) {
+		return
+	}
+	connID := uuid.NewString()
+	ctx := withRemoteIP(withConnID(r.Context(), connID), realIP(r))
+	logger := app.connLogger(ctx)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	// http/1 servers stop reading the body once the response is written unless full duplex is enabled
+	if err := http.NewResponseController(w).EnableFullDuplex(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		logger.Error("Error enabling full duplex:", "err", err)
+	}
+
+	vData, n, err := readVlessRequest(r.Body, make([]byte, buffSize))
+	if err != nil {
+		logger.Error("Error parsing vless data:", "err", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if vData.DstProtocol != "tcp" {
+		logger.Error("Error unsupported protocol:", "network", vData.DstProtocol)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if vData.UUID() != r.PathValue("uid") {
+		logger.Warn("vless user differs from the xhttp path", "uid", vData.UUID())
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	release, err := app.admitUser(vData.UUID(), realIP(r), connID, true, logger)
+	if err != nil {
+		http.Error(w, http.StatusText(admissionStatus(err)), admissionStatus(err))
+		return
+	}
+	defer release()
+	entry := &connEntry{connID: connID, uid: vData.UUID(), remoteIP: realIP(r), target: vData.HostPort(), close: r.Body.Close}
+	app.connOpen(entry)
+	defer app.connClose(connID)
+
+	trafficN := int64(n) + app.xhttpTCP(ctx, vData, w, flusher, r.Body)
+	app.trafficInc(vData.UUID(), trafficN)
+}
+
+// readVlessRequest reads body into buf until it holds a whole VLESS request, a body read may return
+// only the start of it. n is the count of bytes read.
+func readVlessRequest(body io.Reader, buf []byte) (vData *schema.ProtoVLESS, n int, err error) {
+	for {
+		m, readErr := body.Read(buf[n:])
+		n += m
+		if n > 0 {
+			vData, err = schema.VlessParse(buf[:n])
+			if err == nil || readErr != nil || n == len(buf) {
+				return vData, n, err
+			}
+		} else if readErr != nil {
+			return nil, 0, readErr
+		}
+	}
+}
+
+func (app *App) xhttpTCP(ctx context.Context, sv *schema.ProtoVLESS, w http.ResponseWriter, flusher http.Flusher, body io.ReadCloser) int64 {
+	logger := app.connLogger(ctx).With(sv.LogArgs()...)
+	entry := app.connEntryFrom(ctx)
+	conn, headerVLESS, err := app.startDstConnection(ctx, sv, time.Millisecond*1000)
+	if err != nil {
+		logger.Error("Error starting session:", "err", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return 0
+	}
+	defer conn.Close()
+	logger.Info("Session started xhttp")
+
+	entry.addUp(int64(len(sv.DataTcp())))
+	if _, err := conn.Write(sv.DataTcp()); err != nil {
+		logger.Error("Error writing early data to TCP connection:", "err", err)
+		return 0
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(headerVLESS)
+	flusher.Flush()
+
+	throttle := app.sessionThrottle(app.config(), sv.UUID())
+	var trafficMeter atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		n, err := io.Copy(countingWriter{throttledWriter{ctx, throttle, conn}, &trafficMeter}, body)
+		entry.addUp(n)
+		if err != nil {
+			logger.Error("Error writing to TCP connection:", "err", err)
+		}
+		// let the destination see the end of the upload while it may still answer
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			conn.Close()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		defer body.Close() //unblocks the upload once the destination is done
+		app.copyToResponse(throttledWriter{ctx, throttle, w}, flusher, conn, entry, &trafficMeter, logger)
+	}()
+	wg.Wait()
+	return trafficMeter.Load()
+}
+
+// copyToResponse streams destination -> client bytes into the http response, flushing after every read
+func (app *App) copyToResponse(w io.Writer, flusher http.Flusher, conn net.Conn, entry *connEntry, trafficMeter *atomic.Int64, logger *slog.Logger) {
+	bufPtr := app.getBuffer(buffSize)
+	defer app.putBuffer(bufPtr)
+	buf := *bufPtr
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			trafficMeter.Add(int64(n))
+			entry.addDown(int64(n))
+			if _, err := w.Write(buf[:n]); err != nil {
+				logger.Error("Error writing to http response:", "err", err)
+				return
+			}
+			flusher.Flush()
+		}
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			logger.Error("Error reading from TCP connection:", "err", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build linux
+
+package node
+
+import (
+	"os"
+	"strings"
+)
+
+// ecnEnabled reports whether outgoing tcp connections request ECN.
+// Linux has no per socket option for it, net.ipv4.tcp_ecn=1 applies to every connection.
+func ecnEnabled() bool {
+	b, err := os.ReadFile("/proc/sys/net/ipv4/tcp_ecn")
+	return err == nil && strings.TrimSpace(string(b)) == "1"
+}
@@ -0,0 +1,14 @@
+//go:build linux
+
+package node
+
+import (
+	"os"
+	"strings"
+)
+
+// mptcpAvailable reports whether the kernel has mptcp enabled, linux 5.6 or later
+func mptcpAvailable() bool {
+	b, err := os.ReadFile("/proc/sys/net/mptcp/enabled")
+	return err == nil && strings.TrimSpace(string(b)) == "1"
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package node
+
+func mptcpAvailable() bool {
+	return false
+}
@@ -0,0 +1,27 @@
+//go:build linux
+
+package node
+
+import (
+	"net"
+	"syscall"
+)
+
+// soMaxPacingRate is SO_MAX_PACING_RATE, the syscall package does not define it
+const soMaxPacingRate = 47
+
+// enablePacing turns on tcp internal pacing of conn without capping its rate, any SO_MAX_PACING_RATE
+// other than ~0 does that, the largest int is far above any link
+func enablePacing(conn net.Conn) {
+	tcpConn, ok := unwrapTCP(conn)
+	if !ok {
+		return
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return
+	}
+	raw.Control(func(fd uintptr) {
+		syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soMaxPacingRate, 1<<31-1)
+	})
+}
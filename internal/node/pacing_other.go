@@ -0,0 +1,9 @@
+//go:build !linux
+
+package node
+
+import (
+	"net"
+)
+
+func enablePacing(conn net.Conn) {}
@@ -0,0 +1,11 @@
+//go:build !go1.23
+
+package node
+
+import "crypto/tls"
+
+// postQuantumKEM is empty, go 1.22 implements no post quantum key exchange
+const postQuantumKEM = ""
+
+// postQuantumCurves is nil, the go defaults apply
+var postQuantumCurves []tls.CurveID
@@ -0,0 +1,12 @@
+//go:build go1.23 && !go1.24
+
+package node
+
+import "crypto/tls"
+
+const postQuantumKEM = "X25519Kyber768Draft00"
+
+// x25519Kyber768Draft00 is implemented but not exported by go 1.23
+const x25519Kyber768Draft00 tls.CurveID = 0x6399
+
+var postQuantumCurves = []tls.CurveID{x25519Kyber768Draft00, tls.X25519, tls.CurveP256}
@@ -0,0 +1,10 @@
+//go:build go1.24
+
+package node
+
+import "crypto/tls"
+
+const postQuantumKEM = "X25519MLKEM768"
+
+// postQuantumCurves puts the standardized ML-KEM hybrid first, clients without it fall back to X25519
+var postQuantumCurves = []tls.CurveID{tls.X25519MLKEM768, tls.X25519, tls.CurveP256}
@@ -0,0 +1,40 @@
+package node
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Router serves several node apps on one listener, selected by the TLS server name of the request.
+// It is used as the handler of an http.Server whose TLS config has the certificates of all hosts.
+type Router struct {
+	routes   map[string]*App //lower case server name -> app
+	fallback *App
+}
+
+// NewRouter routes requests by SNI to routes, the app of the key "" serves unmatched and plain http requests
+func NewRouter(routes map[string]*App) *Router {
+	rt := &Router{routes: make(map[string]*App, len(routes))}
+	for name, app := range routes {
+		if name == "" {
+			rt.fallback = app
+			continue
+		}
+		rt.routes[strings.ToLower(name)] = app
+	}
+	return rt
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	app := rt.fallback
+	if r.TLS != nil {
+		if routed, ok := rt.routes[strings.ToLower(r.TLS.ServerName)]; ok {
+			app = routed
+		}
+	}
+	if app == nil {
+		http.NotFound(w, r)
+		return
+	}
+	app.ServeHTTP(w, r)
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package node
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func notifyCertReload(ch chan<- os.Signal) {
+	signal.Notify(ch, syscall.SIGUSR1)
+}
+
+func notifyRestart(ch chan<- os.Signal) {
+	signal.Notify(ch, syscall.SIGUSR2)
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package node
+
+import "os"
+
+// windows has no SIGUSR1, certificates are only loaded at startup
+func notifyCertReload(_ chan<- os.Signal) {}
+
+// windows has no SIGUSR2 and no listener inheritance
+func notifyRestart(_ chan<- os.Signal) {}
@@ -0,0 +1,28 @@
+//go:build linux
+
+package node
+
+import (
+	"syscall"
+)
+
+// tcpFastOpenConnect is TCP_FASTOPEN_CONNECT of linux 4.11, the syscall package does not define it
+const tcpFastOpenConnect = 30
+
+// tfoControl enables fast open on a dialing socket, the kernel keeps the cookies per destination.
+// A kernel without it only makes the dial a normal handshake, so the error is logged once and ignored.
+func (app *App) tfoControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpenConnect, 1)
+	})
+	if err == nil {
+		err = sockErr
+	}
+	if err != nil {
+		app.tfoUnsupported.Do(func() {
+			app.logger.Warn("tcp fast open unavailable, dialing without it", "err", err)
+		})
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package node
+
+import (
+	"syscall"
+)
+
+func (app *App) tfoControl(network, address string, c syscall.RawConn) error {
+	app.tfoUnsupported.Do(func() {
+		app.logger.Warn("tcp fast open is only supported on linux, dialing without it")
+	})
+	return nil
+}
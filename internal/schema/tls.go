@@ -0,0 +1,82 @@
+package schema
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	tlsRecordTypeHandshake  = 0x16
+	tlsHandshakeClientHello = 0x01
+	tlsExtServerName        = 0x0000
+	tlsServerNameTypeHost   = 0x00
+)
+
+// IsTLSClientHello reports whether buf starts with a TLS handshake record carrying a ClientHello
+func IsTLSClientHello(buf []byte) bool {
+	return len(buf) > 5 && buf[0] == tlsRecordTypeHandshake && buf[1] == 0x03 && buf[5] == tlsHandshakeClientHello
+}
+
+// TLSServerName extracts the SNI host name from a TLS ClientHello
+// https://datatracker.ietf.org/doc/html/rfc8446#section-4.1.2
+// https://datatracker.ietf.org/doc/html/rfc6066#section-3
+func TLSServerName(buf []byte) (string, error) {
+	if !IsTLSClientHello(buf) {
+		return "", errors.New("not a tls client hello")
+	}
+	// record header(5) + handshake header(4) + client_version(2) + random(32)
+	index := 5 + 4 + 2 + 32
+	if len(buf) < index+1 {
+		return "", errors.New("truncated client hello")
+	}
+	// legacy_session_id
+	index += 1 + int(buf[index])
+	if len(buf) < index+2 {
+		return "", errors.New("truncated client hello session id")
+	}
+	// cipher_suites
+	index += 2 + int(binary.BigEndian.Uint16(buf[index:index+2]))
+	if len(buf) < index+1 {
+		return "", errors.New("truncated client hello cipher suites")
+	}
+	// legacy_compression_methods
+	index += 1 + int(buf[index])
+	if len(buf) < index+2 {
+		return "", errors.New("client hello has no extensions")
+	}
+	extensionsEnd := index + 2 + int(binary.BigEndian.Uint16(buf[index:index+2]))
+	index += 2
+	if extensionsEnd > len(buf) {
+		extensionsEnd = len(buf)
+	}
+	for index+4 <= extensionsEnd {
+		extType := binary.BigEndian.Uint16(buf[index : index+2])
+		extLen := int(binary.BigEndian.Uint16(buf[index+2 : index+4]))
+		index += 4
+		if index+extLen > extensionsEnd {
+			return "", errors.New("truncated client hello extension")
+		}
+		if extType != tlsExtServerName {
+			index += extLen
+			continue
+		}
+		ext := buf[index : index+extLen]
+		if len(ext) < 2 {
+			return "", errors.New("invalid server name extension")
+		}
+		list := ext[2:]
+		for len(list) >= 3 {
+			nameType := list[0]
+			nameLen := int(binary.BigEndian.Uint16(list[1:3]))
+			if len(list) < 3+nameLen {
+				return "", errors.New("truncated server name")
+			}
+			if nameType == tlsServerNameTypeHost {
+				return string(list[3 : 3+nameLen]), nil
+			}
+			list = list[3+nameLen:]
+		}
+		return "", errors.New("server name extension has no host name")
+	}
+	return "", errors.New("client hello has no server name")
+}
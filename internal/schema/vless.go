@@ -19,6 +19,7 @@ type ProtoVLESS struct {
 	dstPort     uint16
 	Version     byte
 	payload     []byte
+	addons      VlessAddons
 }
 
 func (p ProtoTrojan) AuthUser(password string) (isOk bool) {
@@ -76,11 +77,35 @@ func (h ProtoVLESS) HostIP() net.IP {
 	return ip
 }
 
+func (h ProtoVLESS) Host() string {
+	return h.dstHost
+}
+
+func (h ProtoVLESS) Port() int {
+	return int(h.dstPort)
+}
+
 func (h ProtoVLESS) HostPort() string {
 	return net.JoinHostPort(h.dstHost, fmt.Sprintf("%d", h.dstPort))
 }
+
+// Flow is the flow addon of the request, eg. "xtls-rprx-vision"
+func (h ProtoVLESS) Flow() string {
+	return h.addons.Flow
+}
+
+// Addon returns the value of key in the meta addons of the request, eg. "trace-id"
+func (h ProtoVLESS) Addon(key string) string {
+	return h.addons.Meta[key]
+}
+
 func (h ProtoVLESS) Logger() *slog.Logger {
-	return slog.With("userID", h.userID.String(), "network", h.DstProtocol, "addr", h.HostPort())
+	return slog.With(h.LogArgs()...)
+}
+
+// LogArgs are the session attributes of the log lines, eg. logger.With(h.LogArgs()...)
+func (h ProtoVLESS) LogArgs() []any {
+	return []any{"userID", h.userID.String(), "network", h.DstProtocol, "addr", h.HostPort()}
 }
 
 // VlessParse https://xtls.github.io/development/protocols/vless.html
@@ -100,9 +125,21 @@ func VlessParse(buf []byte) (*ProtoVLESS, error) {
 
 	payload.Version = buf[0]
 	payload.userID = uuid.Must(uuid.FromBytes(buf[1:17]))
-	extraInfoProtoBufLen := buf[17]
+	// index math in int, a byte addons length wraps past 255
+	addonsLen := int(buf[17])
+	commandIndex := 18 + addonsLen
+	if len(buf) < commandIndex+4 {
+		return payload, errors.New("invalid addons length")
+	}
+	if addonsLen > 0 {
+		addons, err := parseVlessAddons(buf[18:commandIndex])
+		if err != nil {
+			return payload, fmt.Errorf("parsing addons: %w", err)
+		}
+		payload.addons = addons
+	}
 
-	command := buf[18+extraInfoProtoBufLen]
+	command := buf[commandIndex]
 	switch command {
 	case 1:
 		payload.DstProtocol = "tcp"
@@ -112,7 +149,7 @@ func VlessParse(buf []byte) (*ProtoVLESS, error) {
 		return payload, fmt.Errorf("command %d is not supported, command 01-tcp, 02-udp, 03-mux", command)
 	}
 
-	portIndex := 18 + extraInfoProtoBufLen + 1
+	portIndex := commandIndex + 1
 	payload.dstPort = binary.BigEndian.Uint16(buf[portIndex : portIndex+2])
 
 	addressIndex := portIndex + 2
@@ -121,24 +158,27 @@ func VlessParse(buf []byte) (*ProtoVLESS, error) {
 
 	switch addressType {
 	case 1: // IPv4
-		if len(buf) < int(addressValueIndex+net.IPv4len) {
+		if len(buf) < addressValueIndex+net.IPv4len {
 			return nil, fmt.Errorf("invalid IPv4 address length")
 		}
 		payload.dstHost = net.IP(buf[addressValueIndex : addressValueIndex+net.IPv4len]).String()
 		payload.payload = buf[addressValueIndex+net.IPv4len:]
 		payload.dstHostType = "ipv4"
 	case 2: // domain
-		addressLength := buf[addressValueIndex]
+		if len(buf) < addressValueIndex+1 {
+			return nil, fmt.Errorf("invalid domain address length")
+		}
+		addressLength := int(buf[addressValueIndex])
 		addressValueIndex++
-		if len(buf) < int(addressValueIndex)+int(addressLength) {
+		if len(buf) < addressValueIndex+addressLength {
 			return nil, fmt.Errorf("invalid domain address length")
 		}
-		payload.dstHost = string(buf[addressValueIndex : int(addressValueIndex)+int(addressLength)])
-		payload.payload = buf[int(addressValueIndex)+int(addressLength):]
+		payload.dstHost = string(buf[addressValueIndex : addressValueIndex+addressLength])
+		payload.payload = buf[addressValueIndex+addressLength:]
 		payload.dstHostType = "domain"
 
 	case 3: // IPv6
-		if len(buf) < int(addressValueIndex+net.IPv6len) {
+		if len(buf) < addressValueIndex+net.IPv6len {
 			return nil, fmt.Errorf("invalid IPv6 address length")
 		}
 		payload.dstHost = net.IP(buf[addressValueIndex : addressValueIndex+net.IPv6len]).String()
@@ -150,3 +190,29 @@ func VlessParse(buf []byte) (*ProtoVLESS, error) {
 
 	return payload, nil
 }
+
+// VlessRequest encodes the request header a VLESS client sends before the tcp or udp payload
+func VlessRequest(userID uuid.UUID, network, host string, port uint16) []byte {
+	buf := []byte{0}
+	buf = append(buf, userID[:]...)
+	buf = append(buf, 0) //no addons
+	if network == "udp" {
+		buf = append(buf, 2)
+	} else {
+		buf = append(buf, 1)
+	}
+	buf = binary.BigEndian.AppendUint16(buf, port)
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		buf = append(buf, 2, byte(len(host)))
+		buf = append(buf, host...)
+	case ip.To4() != nil:
+		buf = append(buf, 1)
+		buf = append(buf, ip.To4()...)
+	default:
+		buf = append(buf, 3)
+		buf = append(buf, ip.To16()...)
+	}
+	return buf
+}
@@ -0,0 +1,121 @@
+package schema
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// VlessAddons is the protobuf message between the uuid and the command of a VLESS request
+//
+//	message Addons {
+//	  string flow = 1;
+//	  bytes seed = 2;
+//	  map<string, string> meta = 3; // eg. "trace-id"
+//	}
+type VlessAddons struct {
+	Flow string
+	Seed []byte
+	Meta map[string]string
+}
+
+const (
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+	protoWireFixed32 = 5
+)
+
+// protoFields calls fn with the number and the value of every length delimited field of buf,
+// fields of other wire types are skipped
+func protoFields(buf []byte, fn func(num uint64, value []byte) error) error {
+	for len(buf) > 0 {
+		key, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return errors.New("invalid protobuf field key")
+		}
+		buf = buf[n:]
+		num, wireType := key>>3, key&0x7
+		switch wireType {
+		case protoWireVarint:
+			if _, n = binary.Uvarint(buf); n <= 0 {
+				return errors.New("invalid protobuf varint")
+			}
+			buf = buf[n:]
+		case protoWireFixed64, protoWireFixed32:
+			size := 8
+			if wireType == protoWireFixed32 {
+				size = 4
+			}
+			if len(buf) < size {
+				return errors.New("truncated protobuf fixed field")
+			}
+			buf = buf[size:]
+		case protoWireBytes:
+			size, n := binary.Uvarint(buf)
+			if n <= 0 || uint64(len(buf)-n) < size {
+				return errors.New("truncated protobuf bytes field")
+			}
+			if err := fn(num, buf[n:n+int(size)]); err != nil {
+				return err
+			}
+			buf = buf[n+int(size):]
+		default:
+			return errors.New("unsupported protobuf wire type")
+		}
+	}
+	return nil
+}
+
+func parseVlessAddons(buf []byte) (VlessAddons, error) {
+	addons := VlessAddons{}
+	err := protoFields(buf, func(num uint64, value []byte) error {
+		switch num {
+		case 1:
+			addons.Flow = string(value)
+		case 2:
+			addons.Seed = value
+		case 3:
+			var k, v string
+			err := protoFields(value, func(num uint64, value []byte) error {
+				if num == 1 {
+					k = string(value)
+				} else if num == 2 {
+					v = string(value)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if addons.Meta == nil {
+				addons.Meta = make(map[string]string)
+			}
+			addons.Meta[k] = v
+		}
+		return nil
+	})
+	return addons, err
+}
+
+func appendProtoBytes(buf []byte, num uint64, value []byte) []byte {
+	buf = binary.AppendUvarint(buf, num<<3|protoWireBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+// Marshal encodes the addons in the wire format parsed by VlessParse, meta entries are written in no particular order
+func (a VlessAddons) Marshal() []byte {
+	var buf []byte
+	if a.Flow != "" {
+		buf = appendProtoBytes(buf, 1, []byte(a.Flow))
+	}
+	if len(a.Seed) > 0 {
+		buf = appendProtoBytes(buf, 2, a.Seed)
+	}
+	for k, v := range a.Meta {
+		entry := appendProtoBytes(nil, 1, []byte(k))
+		entry = appendProtoBytes(entry, 2, []byte(v))
+		buf = appendProtoBytes(buf, 3, entry)
+	}
+	return buf
+}
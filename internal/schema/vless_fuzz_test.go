@@ -0,0 +1,52 @@
+package schema
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func FuzzVlessParse(f *testing.F) {
+	uid := uuid.MustParse("903bcd04-79e7-429c-bf0c-0456c7de9cdc")
+	withAddons := func(addons []byte, rest []byte) []byte {
+		buf := append([]byte{0}, uid[:]...)
+		buf = append(buf, byte(len(addons)))
+		buf = append(buf, addons...)
+		return append(buf, rest...)
+	}
+	// valid requests
+	f.Add(VlessRequest(uid, "tcp", "example.com", 443))
+	f.Add(append(VlessRequest(uid, "tcp", "1.2.3.4", 80), "GET / HTTP/1.1\r\n\r\n"...))
+	f.Add(append(VlessRequest(uid, "udp", "2001:db8::1", 53), 0, 2, 0xab, 0xcd))
+	f.Add(withAddons(VlessAddons{Flow: "xtls-rprx-vision", Meta: map[string]string{"trace-id": "abc"}}.Marshal(), []byte{1, 0, 80, 1, 1, 2, 3, 4}))
+	// the addons length used to wrap past 255
+	f.Add(withAddons(append([]byte{0x0a, 0xe5, 0x01}, bytes.Repeat([]byte{'a'}, 229)...), []byte{1, 0, 80, 1, 1, 2, 3, 4}))
+	// invalid requests
+	f.Add([]byte{})
+	f.Add(make([]byte, 23))
+	f.Add(withAddons(nil, []byte{3, 0, 80, 1, 1, 2, 3, 4}))
+	f.Add(withAddons(nil, []byte{1, 0, 80, 2, 200, 'a'}))
+	f.Add(withAddons([]byte{0xff}, []byte{1, 0, 80, 1, 1, 2, 3, 4}))
+	f.Add(withAddons(nil, []byte{1, 0, 80, 9, 1, 2, 3, 4}))
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		p, err := VlessParse(buf)
+		if err != nil {
+			return
+		}
+		encoded := append(VlessRequest(p.userID, p.DstProtocol, p.Host(), p.dstPort), p.DataTcp()...)
+		q, err := VlessParse(encoded)
+		if err != nil {
+			t.Fatalf("parsing the encoded request %x: %v", encoded, err)
+		}
+		if q.UUID() != p.UUID() || q.DstProtocol != p.DstProtocol || q.Port() != p.Port() || !bytes.Equal(q.DataTcp(), p.DataTcp()) {
+			t.Fatalf("round trip of %x changed the request: %s %s to %s %s", buf, p.DstProtocol, p.HostPort(), q.DstProtocol, q.HostPort())
+		}
+		// a domain that looks like an ip is encoded as an ip, the host may change its notation
+		if net.ParseIP(p.Host()) == nil && q.Host() != p.Host() {
+			t.Fatalf("round trip of %x changed the host %q to %q", buf, p.Host(), q.Host())
+		}
+	})
+}